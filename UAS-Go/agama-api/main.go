@@ -8,7 +8,14 @@ import (
 	"strconv"
 	"time"
 
+	"uas/audit"
+	"uas/auth"
+	"uas/i18n"
+	"uas/keamanan"
+	"uas/ratelimit"
+
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -32,10 +39,10 @@ func initDB() (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = db.AutoMigrate(&Agama{})
-    if err != nil {
-        return nil, err
-    }
+	err = db.AutoMigrate(&Agama{}, &i18n.Terjemahan{}, &auth.TokenDicabut{}, &audit.Log{})
+	if err != nil {
+		return nil, err
+	}
 
 	return db, nil
 }
@@ -46,22 +53,45 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	limiter, err := ratelimit.FromEnv(db)
+	if err != nil {
+		panic(err)
+	}
+	keamananConfig, err := keamanan.FromEnv()
+	if err != nil {
+		panic(err)
+	}
 	// inisialisasi handler
 	agamaHandler := NewAgamaHandler(db)
+	terjemahanHandler := i18n.NewTerjemahanHandler(db, "agama")
 
 	e := echo.New()
+	e.IPExtractor = limiter.IPExtractor()
+	e.Use(middleware.RequestID())
+	e.Use(keamanan.Middleware(keamananConfig))
+	e.Use(limiter.PerIP(ratelimit.GroupIP))
+	e.Use(auth.Middleware(db))
+	e.Use(limiter.PerClient(ratelimit.GroupDefault))
+	e.Use(auth.RequireLookup(db))
 	// routing
 	e.GET("/agama", agamaHandler.GetAllAgama)
 	e.GET("/agama/:id", agamaHandler.GetAgamaByID)
 	e.POST("/agama", agamaHandler.CreateAgama)
 	e.PUT("/agama/:id", agamaHandler.UpdateAgama)
 	e.DELETE("/agama/:id", agamaHandler.DeleteAgama)
+	e.GET("/agama/:id/terjemahan", terjemahanHandler.GetAllTerjemahan)
+	e.PUT("/agama/:id/terjemahan/:locale", terjemahanHandler.SetTerjemahan)
+	e.DELETE("/agama/:id/terjemahan/:locale", terjemahanHandler.DeleteTerjemahan)
 	e.Logger.Fatal(e.Start(":1882"))
 }
 
+// Agama is a religion an employee can be recorded with.
 type Agama struct {
-	ID     int64  `json:"id"`
-	Nama    string `json:"nama"`
+	ID        int64     `json:"id"`
+	Nama      string    `json:"nama"`
+	Kode      string    `gorm:"size:20" json:"kode"`
+	Urutan    int       `json:"urutan"`
+	Aktif     bool      `gorm:"default:true" json:"aktif"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -79,20 +109,29 @@ func NewAgamaHandler(db *gorm.DB) *AgamaHandler {
 }
 
 type AgamaRequest struct {
-	ID      string `param:"id"`
-	Nama    string `json:"nama"`
+	ID     string `param:"id"`
+	Nama   string `json:"nama"`
+	Kode   string `json:"kode"`
+	Urutan int    `json:"urutan"`
+	Aktif  *bool  `json:"aktif"`
 }
 
 func (h *AgamaHandler) GetAllAgama(ctx echo.Context) error {
 	search := ctx.QueryParam("search")
 	agama := make([]*Agama, 0)
-	query := h.db.Model(&Agama{})
+	query := h.db.Model(&Agama{}).Order("urutan").Order("id")
+	if ctx.QueryParam("include_inactive") != "true" {
+		query = query.Where("aktif = ?", true)
+	}
 	if search != "" {
 		query = query.Where("nama LIKE ?", "%"+search+"%")
 	}
 	if err := query.Find(&agama).Error; err != nil { // SELECT * FROM users
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Agama"})
 	}
+	if err := i18n.Apply(h.db, "agama", i18n.Locales(ctx), agama, func(x *Agama) (int64, *string) { return x.ID, &x.Nama }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Agama"})
+	}
 	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Users", "data": agama, "filter": search})
 }
 
@@ -103,11 +142,25 @@ func (h *AgamaHandler) CreateAgama(ctx echo.Context) error {
 	}
 
 	agama := &Agama{
-		Nama:    input.Nama,
+		Nama:      input.Nama,
+		Kode:      input.Kode,
+		Urutan:    input.Urutan,
 		CreatedAt: time.Now(),
 	}
 
-	if err := h.db.Create(agama).Error; err != nil { // INSERT INTO users (nim, nama, alamat) VALUES('')
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(agama).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil && !*input.Aktif {
+			if err := tx.Model(agama).Update("aktif", false).Error; err != nil {
+				return err
+			}
+			agama.Aktif = false
+		}
+		return audit.Catat(tx, ctx, "agama", agama.ID, nil, agama)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Create Agama"})
 	}
 
@@ -125,6 +178,9 @@ func (h *AgamaHandler) GetAgamaByID(ctx echo.Context) error {
 	if err := h.db.Where("id =?", input.ID).First(&agama).Error; err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Task By ID"})
 	}
+	if err := i18n.Apply(h.db, "agama", i18n.Locales(ctx), []*Agama{agama}, func(x *Agama) (int64, *string) { return x.ID, &x.Nama }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Task By ID"})
+	}
 
 	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Agama By ID : %s", input.ID), "data": agama})
 }
@@ -140,11 +196,32 @@ func (h *AgamaHandler) UpdateAgama(ctx echo.Context) error {
 	agama := Agama{
 		ID:        int64(agamaID),
 		Nama:      input.Nama,
+		Kode:      input.Kode,
+		Urutan:    input.Urutan,
 		UpdatedAt: time.Now(),
 	}
 
-	query := h.db.Model(&Agama{}).Where("id = ?", agamaID)
-	if err := query.Updates(&agama).Error; err != nil {
+	sebelum := new(Agama)
+	if err := h.db.First(sebelum, agamaID).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Agama not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&Agama{}).Where("id = ?", agamaID).Select("nama", "kode", "urutan", "updated_at")
+		if err := query.Updates(&agama).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil {
+			if err := tx.Model(&Agama{}).Where("id = ?", agamaID).Update("aktif", *input.Aktif).Error; err != nil {
+				return err
+			}
+		}
+		sesudah := new(Agama)
+		if err := tx.First(sesudah, agamaID).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "agama", agamaID, sebelum, sesudah)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Update Agama By ID", "error": err.Error()})
 	}
 
@@ -157,8 +234,18 @@ func (h *AgamaHandler) DeleteAgama(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
 	}
 
-	if err := h.db.Delete(&Agama{}, input.ID).Error; err != nil {
+	sebelum := new(Agama)
+	if err := h.db.Where("id = ?", input.ID).First(sebelum).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Agama not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(sebelum).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "agama", sebelum.ID, sebelum, nil)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Delete Agama By ID"})
 	}
 	return ctx.JSON(http.StatusNoContent, nil)
-}
\ No newline at end of file
+}
@@ -0,0 +1,184 @@
+// Package audit records every change to employees and lookup data in an
+// append-only table: who made it, when, from where, and which fields changed.
+// Handlers call Catat in the transaction of the change, so a change is never
+// stored without its record.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"uas/auth"
+	"uas/enkripsi"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	AksiCreate = "create"
+	AksiUpdate = "update"
+	AksiDelete = "delete"
+)
+
+// ErrAppendOnly is returned when an audit record would be changed.
+var ErrAppendOnly = errors.New("audit: records can't be changed or deleted")
+
+// abaikan are fields left out of the diff, as they change on every write.
+var abaikan = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+}
+
+// terenkripsi are fields stored encrypted, see package enkripsi. Their values
+// are encrypted in the audit log as well.
+var terenkripsi = map[string]bool{
+	"nik": true,
+}
+
+// Log is a change to one record. Entitas is the table of the record and
+// Entitas_ID its primary key. Aktor is the username of the caller, or
+// "apikey:<prefix>" for API keys.
+type Log struct {
+	ID         int64                `json:"id"`
+	Entitas    string               `gorm:"size:50;index:idx_audit_entitas" json:"entitas"`
+	Entitas_ID string               `gorm:"size:50;index:idx_audit_entitas" json:"entitas_id"`
+	Aksi       string               `gorm:"size:10" json:"aksi"`
+	Aktor      string               `gorm:"size:100;index" json:"aktor"`
+	IP         string               `gorm:"size:45" json:"ip"`
+	Request_ID string               `gorm:"size:64" json:"request_id"`
+	Perubahan  map[string]Perubahan `gorm:"serializer:json;type:text" json:"perubahan"`
+	Waktu      time.Time            `gorm:"index" json:"waktu"`
+}
+
+func (Log) TableName() string {
+	return "audit_log"
+}
+
+func (*Log) BeforeUpdate(*gorm.DB) error {
+	return ErrAppendOnly
+}
+
+func (*Log) BeforeDelete(*gorm.DB) error {
+	return ErrAppendOnly
+}
+
+// Perubahan is the value of a field before and after a change.
+type Perubahan struct {
+	Sebelum interface{} `json:"sebelum"`
+	Sesudah interface{} `json:"sesudah"`
+}
+
+// Catat records the change of the record id of entitas from sebelum to
+// sesudah, as seen in their JSON encoding. sebelum is nil for creates and
+// sesudah is nil for deletes; updates that change nothing aren't recorded.
+func Catat(tx *gorm.DB, ctx echo.Context, entitas string, id interface{}, sebelum, sesudah interface{}) error {
+	a, err := toMap(sebelum)
+	if err != nil {
+		return err
+	}
+	b, err := toMap(sesudah)
+	if err != nil {
+		return err
+	}
+
+	aksi := AksiUpdate
+	switch {
+	case a == nil:
+		aksi = AksiCreate
+	case b == nil:
+		aksi = AksiDelete
+	}
+	perubahan := diff(a, b)
+	if aksi == AksiUpdate && len(perubahan) == 0 {
+		return nil
+	}
+	if err := ubahTerenkripsi(perubahan, enkripsi.Enkripsi); err != nil {
+		return err
+	}
+
+	return tx.Create(&Log{
+		Entitas:    entitas,
+		Entitas_ID: fmt.Sprint(id),
+		Aksi:       aksi,
+		Aktor:      Aktor(ctx),
+		IP:         ctx.RealIP(), // see ratelimit.Limiter.IPExtractor
+		Request_ID: requestID(ctx),
+		Perubahan:  perubahan,
+		Waktu:      time.Now(),
+	}).Error
+}
+
+// Aktor names the authenticated caller of the request.
+func Aktor(ctx echo.Context) string {
+	if apiKey := auth.ApiKeyFromContext(ctx); apiKey != nil {
+		return "apikey:" + apiKey.Prefix
+	}
+	if claims := auth.FromContext(ctx); claims != nil {
+		return claims.Username
+	}
+	return ""
+}
+
+// requestID returns the ID set by the RequestID middleware, falling back to
+// the one sent by the client.
+func requestID(ctx echo.Context) string {
+	if id := ctx.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	return ctx.Request().Header.Get(echo.HeaderXRequestID)
+}
+
+// ubahTerenkripsi applies f, Enkripsi or Dekripsi, to the string values of
+// the encrypted fields in perubahan.
+func ubahTerenkripsi(perubahan map[string]Perubahan, f func(string) (string, error)) error {
+	for k, p := range perubahan {
+		if !terenkripsi[k] {
+			continue
+		}
+		for _, v := range []*interface{}{&p.Sebelum, &p.Sesudah} {
+			if s, ok := (*v).(string); ok {
+				var err error
+				if *v, err = f(s); err != nil {
+					return err
+				}
+			}
+		}
+		perubahan[k] = p
+	}
+	return nil
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// diff returns the fields whose value differs between a and b.
+func diff(a, b map[string]interface{}) map[string]Perubahan {
+	perubahan := make(map[string]Perubahan)
+	for k, v := range a {
+		if !abaikan[k] && !reflect.DeepEqual(v, b[k]) {
+			perubahan[k] = Perubahan{Sebelum: v, Sesudah: b[k]}
+		}
+	}
+	for k, v := range b {
+		if _, ok := a[k]; !ok && !abaikan[k] && v != nil {
+			perubahan[k] = Perubahan{Sesudah: v}
+		}
+	}
+	return perubahan
+}
@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b map[string]interface{}
+		want map[string]Perubahan
+	}{
+		{
+			"create",
+			nil,
+			map[string]interface{}{"id": 1.0, "nama": "Budi", "gambar": nil, "created_at": "2024-01-01"},
+			map[string]Perubahan{"id": {Sesudah: 1.0}, "nama": {Sesudah: "Budi"}},
+		},
+		{
+			"delete",
+			map[string]interface{}{"id": 1.0, "nama": "Budi", "gambar": nil},
+			nil,
+			map[string]Perubahan{"id": {Sebelum: 1.0}, "nama": {Sebelum: "Budi"}},
+		},
+		{
+			"update",
+			map[string]interface{}{"id": 1.0, "nama": "Budi", "unit": "FT", "updated_at": "2024-01-01"},
+			map[string]interface{}{"id": 1.0, "nama": "Budi Santoso", "unit": "FT", "updated_at": "2024-02-01"},
+			map[string]Perubahan{"nama": {Sebelum: "Budi", Sesudah: "Budi Santoso"}},
+		},
+		{
+			"field added and removed",
+			map[string]interface{}{"lama": "x"},
+			map[string]interface{}{"baru": "y"},
+			map[string]Perubahan{"lama": {Sebelum: "x"}, "baru": {Sesudah: "y"}},
+		},
+		{
+			"nested values compared deeply",
+			map[string]interface{}{"tags": []interface{}{"a"}},
+			map[string]interface{}{"tags": []interface{}{"a"}},
+			map[string]Perubahan{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diff(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diff() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
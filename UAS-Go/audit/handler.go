@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"uas/auth"
+	"uas/enkripsi"
+	"uas/privasi"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Handler serves the audit log.
+type Handler struct {
+	db *gorm.DB
+}
+
+func NewHandler(db *gorm.DB) *Handler {
+	return &Handler{db: db}
+}
+
+// GetAllAudit lists audit records, newest first, filtered by ?entitas=,
+// ?entitas_id=, ?aktor=, and ?dari= and ?sampai= as YYYY-MM-DD (both
+// inclusive) or RFC 3339. ?limit= (at most 1000) and ?offset= page through
+// the result.
+func (h *Handler) GetAllAudit(ctx echo.Context) error {
+	query := h.db.Model(&Log{})
+	for _, f := range []string{"entitas", "entitas_id", "aktor"} {
+		if v := ctx.QueryParam(f); v != "" {
+			query = query.Where(f+" = ?", v)
+		}
+	}
+	return h.list(ctx, query)
+}
+
+// GetAuditEntitas returns a handler listing the audit records of the entitas
+// record named by the :id parameter, with the filters of GetAllAudit.
+func (h *Handler) GetAuditEntitas(entitas string) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		query := h.db.Model(&Log{}).Where("entitas = ? AND entitas_id = ?", entitas, ctx.Param("id"))
+		if v := ctx.QueryParam("aktor"); v != "" {
+			query = query.Where("aktor = ?", v)
+		}
+		return h.list(ctx, query)
+	}
+}
+
+func (h *Handler) list(ctx echo.Context, query *gorm.DB) error {
+	if v := ctx.QueryParam("dari"); v != "" {
+		dari, _, err := parseWaktu(v)
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "dari must be formatted as YYYY-MM-DD or RFC 3339"})
+		}
+		query = query.Where("waktu >= ?", dari)
+	}
+	if v := ctx.QueryParam("sampai"); v != "" {
+		sampai, tanggal, err := parseWaktu(v)
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "sampai must be formatted as YYYY-MM-DD or RFC 3339"})
+		}
+		if tanggal {
+			query = query.Where("waktu < ?", sampai.AddDate(0, 0, 1))
+		} else {
+			query = query.Where("waktu <= ?", sampai)
+		}
+	}
+
+	limit, err := strconv.Atoi(ctx.QueryParam("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset, _ := strconv.Atoi(ctx.QueryParam("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Audit"})
+	}
+	logs := make([]*Log, 0)
+	if err := query.Order("waktu DESC").Order("id DESC").Limit(limit).Offset(offset).Find(&logs).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Audit"})
+	}
+	for _, l := range logs {
+		if err := ubahTerenkripsi(l.Perubahan, enkripsi.Dekripsi); err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Audit"})
+		}
+	}
+
+	// Changed values of sensitive fields are masked like everywhere else
+	akses, err := auth.AksesFrom(h.db, ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to load permissions"})
+	}
+	data, err := privasi.Untuk(akses).Terapkan(logs)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Audit"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get Audit", "data": data, "total": total})
+}
+
+// parseWaktu parses a date or an RFC 3339 time; tanggal reports a date.
+func parseWaktu(v string) (t time.Time, tanggal bool, err error) {
+	if t, err = time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, v)
+	return t, false, err
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	// HeaderAPIKey carries the API key of a calling system.
+	HeaderAPIKey = "X-API-Key"
+
+	// apiKeyPrefix starts every key, so leaked keys are easy to recognize.
+	apiKeyPrefix = "uas_"
+
+	// terakhirDipakaiInterval limits the writes of the last-used timestamp.
+	terakhirDipakaiInterval = time.Minute
+)
+
+// apiKeyIzin are the permissions an API key may be given, besides the per
+// field PegawaiSensitif ones. Keys can't manage users or other keys.
+var apiKeyIzin = map[string]bool{
+	PegawaiRead:     true,
+	PegawaiWrite:    true,
+	PegawaiSensitif: true,
+	LookupRead:      true,
+	LookupWrite:     true,
+}
+
+// ApiKey lets another system call the services without a user login. Only
+// the SHA-256 of the key is stored; Prefix is its start, shown to tell keys
+// apart. The permissions of a key are never limited to units.
+type ApiKey struct {
+	ID               int64        `json:"id"`
+	Nama             string       `gorm:"size:100" json:"nama"`
+	Prefix           string       `gorm:"size:16" json:"prefix"`
+	Key_Hash         string       `gorm:"size:64;uniqueIndex" json:"-"`
+	Izin             []ApiKeyIzin `gorm:"foreignKey:Api_Key_ID" json:"izin"`
+	Dibuat_Oleh      int64        `json:"dibuat_oleh"`
+	Terakhir_Dipakai *time.Time   `json:"terakhir_dipakai"`
+	Dicabut          *time.Time   `json:"dicabut"`
+	CreatedAt        time.Time    `json:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at"`
+}
+
+func (ApiKey) TableName() string {
+	return "api_key"
+}
+
+type ApiKeyIzin struct {
+	Api_Key_ID int64  `gorm:"primaryKey" json:"-"`
+	Izin       string `gorm:"size:50;primaryKey" json:"izin"`
+}
+
+func (ApiKeyIzin) TableName() string {
+	return "api_key_izin"
+}
+
+type ApiKeyRequest struct {
+	ID   string   `param:"id"`
+	Nama string   `json:"nama"`
+	Izin []string `json:"izin"`
+}
+
+// apiKeyContextKey is the echo.Context key of the verified ApiKey.
+const apiKeyContextKey = "auth.apikey"
+
+// errApiKey is returned by verifyApiKey for unknown and revoked keys.
+var errApiKey = errors.New("auth: invalid API key")
+
+// newApiKey generates a key and returns it with its display prefix and hash.
+func newApiKey() (key, prefix, hash string, err error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + token
+	return key, key[:len(apiKeyPrefix)+8], hashToken(key), nil
+}
+
+// verifyApiKey looks up an unrevoked key and records its use.
+func verifyApiKey(db *gorm.DB, key string) (*ApiKey, error) {
+	apiKey := new(ApiKey)
+	err := db.Preload("Izin").Where("key_hash = ? AND dicabut IS NULL", hashToken(key)).First(apiKey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errApiKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if apiKey.Terakhir_Dipakai == nil || now.Sub(*apiKey.Terakhir_Dipakai) > terakhirDipakaiInterval {
+		if err := db.Model(apiKey).UpdateColumn("terakhir_dipakai", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return apiKey, nil
+}
+
+// ApiKeyFromContext returns the API key the request was authenticated with,
+// or nil.
+func ApiKeyFromContext(ctx echo.Context) *ApiKey {
+	apiKey, _ := ctx.Get(apiKeyContextKey).(*ApiKey)
+	return apiKey
+}
+
+// validasiIzin returns the first permission an API key may not have.
+func validasiIzin(izin []string) (string, bool) {
+	for _, i := range izin {
+		if !apiKeyIzin[i] && !strings.HasPrefix(i, PegawaiSensitif+":") {
+			return i, false
+		}
+	}
+	return "", true
+}
+
+func (h *Handler) GetAllApiKey(ctx echo.Context) error {
+	keys := make([]*ApiKey, 0)
+	if err := h.db.Preload("Izin").Order("nama").Find(&keys).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All API Key"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All API Key", "data": keys})
+}
+
+// CreateApiKey creates a key. The key itself is only part of this response.
+func (h *Handler) CreateApiKey(ctx echo.Context) error {
+	var input ApiKeyRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	if input.Nama == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "nama is required"})
+	}
+	if len(input.Izin) == 0 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "izin is required"})
+	}
+	if izin, ok := validasiIzin(input.Izin); !ok {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "API keys can't have izin " + izin})
+	}
+	key, prefix, hash, err := newApiKey()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create API Key"})
+	}
+
+	apiKey := &ApiKey{Nama: input.Nama, Prefix: prefix, Key_Hash: hash}
+	if claims := FromContext(ctx); claims != nil {
+		apiKey.Dibuat_Oleh = claims.UserID()
+	}
+	for _, izin := range input.Izin {
+		apiKey.Izin = append(apiKey.Izin, ApiKeyIzin{Izin: izin})
+	}
+	if err := h.db.Create(apiKey).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create API Key"})
+	}
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "API Key created successfully, store the key now as it can't be shown again",
+		"data":    map[string]interface{}{"api_key": apiKey, "key": key},
+	})
+}
+
+// RotateApiKey replaces the key of an API key, keeping its permissions. The
+// old key stops working immediately.
+func (h *Handler) RotateApiKey(ctx echo.Context) error {
+	var input ApiKeyRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	apiKey := new(ApiKey)
+	if err := h.db.Preload("Izin").Where("id = ? AND dicabut IS NULL", input.ID).First(apiKey).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "API Key not found"})
+	}
+	key, prefix, hash, err := newApiKey()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to rotate API Key"})
+	}
+
+	err = h.db.Model(apiKey).Updates(map[string]interface{}{
+		"prefix":           prefix,
+		"key_hash":         hash,
+		"terakhir_dipakai": nil,
+	}).Error
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to rotate API Key"})
+	}
+	apiKey.Prefix, apiKey.Terakhir_Dipakai = prefix, nil
+	return ctx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "API Key rotated successfully, store the key now as it can't be shown again",
+		"data":    map[string]interface{}{"api_key": apiKey, "key": key},
+	})
+}
+
+// DeleteApiKey revokes an API key. The row is kept for reference.
+func (h *Handler) DeleteApiKey(ctx echo.Context) error {
+	var input ApiKeyRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	result := h.db.Model(&ApiKey{}).Where("id = ? AND dicabut IS NULL", input.ID).Update("dicabut", time.Now())
+	if result.Error != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to revoke API Key"})
+	}
+	if result.RowsAffected == 0 {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "API Key not found"})
+	}
+	return ctx.JSON(http.StatusNoContent, nil)
+}
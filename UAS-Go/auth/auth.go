@@ -0,0 +1,233 @@
+// Package auth authenticates requests to the services with JWTs. Users log
+// in with a username and password at pegawai-api-2 and receive a short-lived
+// access token and a longer-lived refresh token. Every service verifies the
+// access token with Middleware, using the shared JWT_SECRET and the shared
+// table of revoked tokens. Other systems authenticate with API keys instead.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultAccessTTL  = 15 * time.Minute
+	defaultRefreshTTL = 7 * 24 * time.Hour
+
+	// minPasswordLength is the shortest password accepted for new passwords.
+	minPasswordLength = 8
+)
+
+// User is an account that can log in.
+type User struct {
+	ID            int64     `json:"id"`
+	Username      string    `gorm:"size:100;uniqueIndex" json:"username"`
+	Password_Hash string    `json:"-"`
+	Nama          string    `json:"nama"`
+	Aktif         bool      `gorm:"default:true" json:"aktif"`
+	Pegawai_ID    *int64    `gorm:"uniqueIndex" json:"pegawai_id"` // own employee record, for self-service
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (User) TableName() string {
+	return "users"
+}
+
+// RefreshToken is an issued refresh token. Only its SHA-256 is stored.
+// Refreshing revokes the used token and issues a new one; presenting a
+// revoked token again revokes every token of the user, as it was probably
+// stolen.
+type RefreshToken struct {
+	ID         int64      `json:"id"`
+	User_ID    int64      `gorm:"index" json:"user_id"`
+	Token_Hash string     `gorm:"size:64;uniqueIndex" json:"-"`
+	Kadaluarsa time.Time  `json:"kadaluarsa"`
+	Dicabut    *time.Time `json:"dicabut"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_token"
+}
+
+// TokenDicabut is a revoked access token, kept until it would have expired
+// anyway. User-wide revocations, e.g. after a password change, are recorded
+// with JTI "user:<id>" and reject every token of the user issued before
+// Dicabut, which is truncated to the second like the iat claim.
+type TokenDicabut struct {
+	JTI        string    `gorm:"size:64;primaryKey" json:"jti"`
+	Kadaluarsa time.Time `gorm:"index" json:"kadaluarsa"`
+	Dicabut    time.Time `json:"dicabut"`
+}
+
+func (TokenDicabut) TableName() string {
+	return "token_dicabut"
+}
+
+// Models lists the tables of this package, for AutoMigrate.
+func Models() []interface{} {
+	return []interface{}{&User{}, &RefreshToken{}, &TokenDicabut{}, &Role{}, &RoleIzin{}, &UserRole{}, &UserUnit{}, &ApiKey{}, &ApiKeyIzin{}}
+}
+
+// Claims are the claims of an access token.
+type Claims struct {
+	Username string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the ID of the user the token was issued to.
+func (c *Claims) UserID() int64 {
+	id, _ := strconv.ParseInt(c.Subject, 10, 64)
+	return id
+}
+
+// secret signs access tokens. Every service must use the same JWT_SECRET;
+// without it a random secret is used, which only works for a single process
+// and logs everybody out on restart.
+var secret = sync.OnceValue(func() []byte {
+	if value := os.Getenv("JWT_SECRET"); value != "" {
+		return []byte(value)
+	}
+	log.Print("auth: JWT_SECRET is not set, tokens are only valid in this process")
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+})
+
+// ttl reads a duration from the environment variable key.
+func ttl(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// accessTTL and refreshTTL are configured with JWT_ACCESS_TTL and
+// JWT_REFRESH_TTL, e.g. "15m" and "168h".
+func accessTTL() time.Duration  { return ttl("JWT_ACCESS_TTL", defaultAccessTTL) }
+func refreshTTL() time.Duration { return ttl("JWT_REFRESH_TTL", defaultRefreshTTL) }
+
+// newAccessToken signs an access token for user.
+func newAccessToken(user *User) (string, *Claims, error) {
+	jti, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	claims := &Claims{
+		Username: user.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTTL())),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret())
+	return token, claims, err
+}
+
+// parseAccessToken verifies the signature and expiry of token.
+func parseAccessToken(token string) (*Claims, error) {
+	claims := new(Claims)
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return secret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// dicabut reports whether the access token was revoked, by itself or through
+// a user-wide revocation.
+func dicabut(db *gorm.DB, claims *Claims) (bool, error) {
+	var rows []TokenDicabut
+	err := db.Where("jti IN ?", []string{claims.ID, "user:" + claims.Subject}).Find(&rows).Error
+	if err != nil {
+		return false, err
+	}
+	for _, row := range rows {
+		if row.JTI == claims.ID || claims.IssuedAt == nil || claims.IssuedAt.Time.Before(row.Dicabut) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// HashPassword returns the bcrypt hash of password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword reports whether password matches the bcrypt hash.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnsureAdmin creates the first account from AUTH_ADMIN_USERNAME and
+// AUTH_ADMIN_PASSWORD when the users table is empty, so a fresh installation
+// can be logged into, and makes sure that account has the admin role.
+func EnsureAdmin(db *gorm.DB) error {
+	username, password := os.Getenv("AUTH_ADMIN_USERNAME"), os.Getenv("AUTH_ADMIN_PASSWORD")
+	var count int64
+	if err := db.Model(&User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if username == "" || password == "" {
+		if count == 0 {
+			log.Print("auth: no users exist; set AUTH_ADMIN_USERNAME and AUTH_ADMIN_PASSWORD to create the first one")
+		}
+		return nil
+	}
+	if count == 0 {
+		if len(password) < minPasswordLength {
+			return errors.New("auth: AUTH_ADMIN_PASSWORD is too short")
+		}
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+		if err := db.Create(&User{Username: username, Password_Hash: hash, Nama: username, Aktif: true}).Error; err != nil {
+			return err
+		}
+	}
+
+	user, role := new(User), new(Role)
+	if err := db.Where("username = ?", username).First(user).Error; err != nil {
+		return err
+	}
+	if err := db.Where("nama = ?", "admin").First(role).Error; err != nil {
+		return err
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&UserRole{User_ID: user.ID, Role_ID: role.ID}).Error
+}
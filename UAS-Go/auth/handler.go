@@ -0,0 +1,475 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Handler serves login, token refresh, logout, password change and the
+// management of user accounts.
+type Handler struct {
+	db *gorm.DB
+}
+
+func NewHandler(db *gorm.DB) *Handler {
+	return &Handler{db: db}
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type RefreshRequest struct {
+	Refresh_Token string `json:"refresh_token"`
+}
+
+type PasswordRequest struct {
+	Password_Lama string `json:"password_lama"`
+	Password_Baru string `json:"password_baru"`
+}
+
+type UserRequest struct {
+	ID         string   `param:"id"`
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	Nama       string   `json:"nama"`
+	Roles      []string `json:"roles"`
+	Units      []string `json:"units"`
+	Pegawai_ID *int64   `json:"pegawai_id"`
+}
+
+type RoleRequest struct {
+	ID            string   `param:"id"`
+	Nama          string   `json:"nama"`
+	Keterangan    string   `json:"keterangan"`
+	Terbatas_Unit bool     `json:"terbatas_unit"`
+	Izin          []string `json:"izin"`
+}
+
+// tokens issues a new access and refresh token pair for user.
+func (h *Handler) tokens(tx *gorm.DB, user *User) (map[string]interface{}, error) {
+	access, claims, err := newAccessToken(user)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Create(&RefreshToken{
+		User_ID:    user.ID,
+		Token_Hash: hashToken(refresh),
+		Kadaluarsa: time.Now().Add(refreshTTL()),
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    int(time.Until(claims.ExpiresAt.Time).Seconds()),
+		"refresh_token": refresh,
+		"user":          user,
+	}, nil
+}
+
+// Login checks the credentials and issues a token pair.
+func (h *Handler) Login(ctx echo.Context) error {
+	var input LoginRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	user := new(User)
+	err := h.db.Where("username = ?", input.Username).First(user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to login"})
+	}
+	// Unknown users and wrong passwords get the same answer
+	if err != nil || !user.Aktif || !CheckPassword(user.Password_Hash, input.Password) {
+		return ctx.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid username or password"})
+	}
+
+	data, err := h.tokens(h.db, user)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to login"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Login successful", "data": data})
+}
+
+// Refresh exchanges a refresh token for a new token pair. The used refresh
+// token is revoked.
+func (h *Handler) Refresh(ctx echo.Context) error {
+	var input RefreshRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	var data map[string]interface{}
+	var reused bool
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		token := new(RefreshToken)
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(input.Refresh_Token)).First(token).Error
+		if err != nil {
+			return err
+		}
+		if token.Dicabut != nil {
+			reused = true
+			return gorm.ErrRecordNotFound
+		}
+		if time.Now().After(token.Kadaluarsa) {
+			return gorm.ErrRecordNotFound
+		}
+		user := new(User)
+		if err := tx.First(user, token.User_ID).Error; err != nil || !user.Aktif {
+			return gorm.ErrRecordNotFound
+		}
+
+		now := time.Now()
+		if err := tx.Model(token).Update("dicabut", &now).Error; err != nil {
+			return err
+		}
+		data, err = h.tokens(tx, user)
+		return err
+	})
+	if reused {
+		// A rotated token was presented again, so it has leaked: log the user
+		// out everywhere.
+		token := new(RefreshToken)
+		if h.db.Where("token_hash = ?", hashToken(input.Refresh_Token)).First(token).Error == nil {
+			cabutSemua(h.db, token.User_ID)
+		}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ctx.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid or expired refresh token"})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to refresh token"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Token refreshed successfully", "data": data})
+}
+
+// Logout revokes the caller's access token and, when given, its refresh
+// token.
+func (h *Handler) Logout(ctx echo.Context) error {
+	claims := FromContext(ctx)
+	if claims == nil {
+		return unauthorized(ctx, "Missing access token")
+	}
+	var input RefreshRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := cabut(tx, claims.ID, claims.ExpiresAt.Time, time.Now()); err != nil {
+			return err
+		}
+		if input.Refresh_Token == "" {
+			return nil
+		}
+		return tx.Model(&RefreshToken{}).
+			Where("token_hash = ? AND user_id = ? AND dicabut IS NULL", hashToken(input.Refresh_Token), claims.UserID()).
+			Update("dicabut", time.Now()).Error
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to logout"})
+	}
+	return ctx.JSON(http.StatusNoContent, nil)
+}
+
+// UbahPassword changes the caller's password and logs them out everywhere.
+func (h *Handler) UbahPassword(ctx echo.Context) error {
+	claims := FromContext(ctx)
+	if claims == nil {
+		return unauthorized(ctx, "Missing access token")
+	}
+	var input PasswordRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	if len(input.Password_Baru) < minPasswordLength {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("password_baru must be at least %d characters", minPasswordLength)})
+	}
+
+	user := new(User)
+	if err := h.db.First(user, claims.UserID()).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "User not found"})
+	}
+	if !CheckPassword(user.Password_Hash, input.Password_Lama) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "password_lama is wrong"})
+	}
+	hash, err := HashPassword(input.Password_Baru)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to change password"})
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password_hash", hash).Error; err != nil {
+			return err
+		}
+		return cabutSemua(tx, user.ID)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to change password"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{"message": "Password changed successfully, please login again"})
+}
+
+func (h *Handler) GetAllUser(ctx echo.Context) error {
+	users := make([]*User, 0)
+	if err := h.db.Order("username").Find(&users).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All User"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All User", "data": users})
+}
+
+func (h *Handler) CreateUser(ctx echo.Context) error {
+	var input UserRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	if input.Username == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "username is required"})
+	}
+	if len(input.Password) < minPasswordLength {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("password must be at least %d characters", minPasswordLength)})
+	}
+	hash, err := HashPassword(input.Password)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create User"})
+	}
+
+	user := &User{Username: input.Username, Password_Hash: hash, Nama: input.Nama, Aktif: true}
+	err = h.db.Create(user).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": "Username is already taken"})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create User"})
+	}
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "User created successfully", "data": user})
+}
+
+// DeleteUser deactivates an account and revokes its tokens. The row is kept
+// so references to it stay valid.
+func (h *Handler) DeleteUser(ctx echo.Context) error {
+	var input UserRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	user := new(User)
+	if err := h.db.Where("id = ?", input.ID).First(user).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "User not found"})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("aktif", false).Error; err != nil {
+			return err
+		}
+		return cabutSemua(tx, user.ID)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to delete User"})
+	}
+	return ctx.JSON(http.StatusNoContent, nil)
+}
+
+func (h *Handler) GetAllRole(ctx echo.Context) error {
+	roles := make([]*Role, 0)
+	if err := h.db.Preload("Izin").Order("nama").Find(&roles).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Role"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Role", "data": roles})
+}
+
+func (h *Handler) CreateRole(ctx echo.Context) error {
+	var input RoleRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	if input.Nama == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "nama is required"})
+	}
+
+	role := &Role{Nama: input.Nama, Keterangan: input.Keterangan, Terbatas_Unit: input.Terbatas_Unit}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(role).Error; err != nil {
+			return err
+		}
+		return setIzin(tx, role, input.Izin)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": "Role already exists"})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Role"})
+	}
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Role created successfully", "data": role})
+}
+
+// UpdateRole replaces the description, unit restriction and permissions of a
+// role.
+func (h *Handler) UpdateRole(ctx echo.Context) error {
+	var input RoleRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	role := new(Role)
+	if err := h.db.Where("id = ?", input.ID).First(role).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Role not found"})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(role).Updates(map[string]interface{}{
+			"keterangan":    input.Keterangan,
+			"terbatas_unit": input.Terbatas_Unit,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", role.ID).Delete(&RoleIzin{}).Error; err != nil {
+			return err
+		}
+		return setIzin(tx, role, input.Izin)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Role"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Role updated successfully", "data": role})
+}
+
+func setIzin(tx *gorm.DB, role *Role, izin []string) error {
+	role.Izin = make([]RoleIzin, 0, len(izin))
+	for _, i := range izin {
+		role.Izin = append(role.Izin, RoleIzin{Role_ID: role.ID, Izin: i})
+	}
+	if len(role.Izin) == 0 {
+		return nil
+	}
+	return tx.Create(&role.Izin).Error
+}
+
+// SetUserRoles replaces the roles of a user, given by name.
+func (h *Handler) SetUserRoles(ctx echo.Context) error {
+	var input UserRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	user := new(User)
+	if err := h.db.Where("id = ?", input.ID).First(user).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "User not found"})
+	}
+	roles := make([]*Role, 0)
+	if len(input.Roles) > 0 {
+		if err := h.db.Where("nama IN ?", input.Roles).Find(&roles).Error; err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to set Roles"})
+		}
+	}
+	if len(roles) != len(input.Roles) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Unknown role in roles"})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		for _, role := range roles {
+			if err := tx.Create(&UserRole{User_ID: user.ID, Role_ID: role.ID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to set Roles"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Roles set successfully", "data": input.Roles})
+}
+
+// SetUserUnits replaces the units in the organizational scope of a user.
+func (h *Handler) SetUserUnits(ctx echo.Context) error {
+	var input UserRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	user := new(User)
+	if err := h.db.Where("id = ?", input.ID).First(user).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "User not found"})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&UserUnit{}).Error; err != nil {
+			return err
+		}
+		for _, unit := range input.Units {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&UserUnit{User_ID: user.ID, Unit: unit}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to set Units"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Units set successfully", "data": input.Units})
+}
+
+// SetUserPegawai links a user to the employee record of its owner, or
+// unlinks it when pegawai_id is null. An employee has at most one account.
+func (h *Handler) SetUserPegawai(ctx echo.Context) error {
+	var input UserRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	user := new(User)
+	if err := h.db.Where("id = ?", input.ID).First(user).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "User not found"})
+	}
+	if input.Pegawai_ID != nil {
+		var count int64
+		if err := h.db.Table("pegawai").Where("id = ? AND deleted_at IS NULL", *input.Pegawai_ID).Count(&count).Error; err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Pegawai"})
+		}
+		if count == 0 {
+			return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pegawai not found"})
+		}
+	}
+
+	err := h.db.Model(user).Update("pegawai_id", input.Pegawai_ID).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": "Pegawai is already linked to another User"})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to link Pegawai"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Pegawai linked successfully", "data": user})
+}
+
+// cabut records jti as revoked until it expires.
+func cabut(tx *gorm.DB, jti string, kadaluarsa, dicabut time.Time) error {
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&TokenDicabut{JTI: jti, Kadaluarsa: kadaluarsa, Dicabut: dicabut.Truncate(time.Second)}).Error
+}
+
+// cabutSemua revokes every access and refresh token of the user and removes
+// revocations that have expired.
+func cabutSemua(tx *gorm.DB, userID int64) error {
+	now := time.Now()
+	if err := cabut(tx, fmt.Sprintf("user:%d", userID), now.Add(accessTTL()), now); err != nil {
+		return err
+	}
+	if err := tx.Where("kadaluarsa < ?", now).Delete(&TokenDicabut{}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&RefreshToken{}).Where("user_id = ? AND dicabut IS NULL", userID).Update("dicabut", now).Error
+}
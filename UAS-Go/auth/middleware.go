@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// contextKey is the echo.Context key of the verified Claims.
+const contextKey = "auth.claims"
+
+// publicPaths can be called without an access token.
+var publicPaths = map[string]bool{
+	"/auth/login":   true,
+	"/auth/refresh": true,
+}
+
+// Middleware rejects requests without a valid, unrevoked access token in the
+// Authorization: Bearer header or API key in the X-API-Key header with 401.
+// The login and refresh endpoints are
+// always public; skip can exempt further requests, e.g. ones that carry their
+// own signature.
+func Middleware(db *gorm.DB, skip ...func(echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if publicPaths[ctx.Path()] {
+				return next(ctx)
+			}
+			for _, s := range skip {
+				if s(ctx) {
+					return next(ctx)
+				}
+			}
+
+			if key := ctx.Request().Header.Get(HeaderAPIKey); key != "" {
+				apiKey, err := verifyApiKey(db, key)
+				if errors.Is(err, errApiKey) {
+					return unauthorized(ctx, "Invalid or revoked API key")
+				}
+				if err != nil {
+					return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to verify API key"})
+				}
+				ctx.Set(apiKeyContextKey, apiKey)
+				return next(ctx)
+			}
+
+			token, ok := strings.CutPrefix(ctx.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if !ok || token == "" {
+				return unauthorized(ctx, "Missing access token")
+			}
+			claims, err := parseAccessToken(token)
+			if err != nil {
+				return unauthorized(ctx, "Invalid or expired access token")
+			}
+			revoked, err := dicabut(db, claims)
+			if err != nil {
+				return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to verify access token"})
+			}
+			if revoked {
+				return unauthorized(ctx, "Access token has been revoked")
+			}
+
+			ctx.Set(contextKey, claims)
+			return next(ctx)
+		}
+	}
+}
+
+func unauthorized(ctx echo.Context, message string) error {
+	ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="uas"`)
+	return ctx.JSON(http.StatusUnauthorized, map[string]string{"message": message})
+}
+
+// FromContext returns the claims of the authenticated caller, or nil for
+// requests that skipped authentication or used an API key.
+func FromContext(ctx echo.Context) *Claims {
+	claims, _ := ctx.Get(contextKey).(*Claims)
+	return claims
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Permissions checked by the services. A role with SemuaIzin has every
+// permission. PegawaiSensitif shows sensitive personal data in full; it can
+// also be granted per field, e.g. "pegawai:sensitif:nik", see package
+// privasi.
+const (
+	SemuaIzin    = "*"
+	PegawaiRead  = "pegawai:read"
+	PegawaiWrite = "pegawai:write"
+	LookupRead   = "lookup:read"
+	LookupWrite  = "lookup:write"
+	UserManage   = "user:manage"
+	AuditRead    = "audit:read"
+
+	PegawaiSensitif = "pegawai:sensitif"
+)
+
+// Role groups permissions. The permissions of a role with Terbatas_Unit only
+// apply to employees in the units assigned to the user, see UserUnit.
+type Role struct {
+	ID            int64      `json:"id"`
+	Nama          string     `gorm:"size:50;uniqueIndex" json:"nama"`
+	Keterangan    string     `json:"keterangan"`
+	Terbatas_Unit bool       `json:"terbatas_unit"`
+	Izin          []RoleIzin `gorm:"foreignKey:Role_ID" json:"izin"`
+}
+
+func (Role) TableName() string {
+	return "role"
+}
+
+type RoleIzin struct {
+	Role_ID int64  `gorm:"primaryKey" json:"-"`
+	Izin    string `gorm:"size:50;primaryKey" json:"izin"`
+}
+
+func (RoleIzin) TableName() string {
+	return "role_izin"
+}
+
+type UserRole struct {
+	User_ID int64 `gorm:"primaryKey"`
+	Role_ID int64 `gorm:"primaryKey"`
+}
+
+func (UserRole) TableName() string {
+	return "user_role"
+}
+
+// UserUnit is a unit in the organizational scope of a user.
+type UserUnit struct {
+	User_ID int64  `gorm:"primaryKey"`
+	Unit    string `gorm:"size:100;primaryKey"`
+}
+
+func (UserUnit) TableName() string {
+	return "user_unit"
+}
+
+// defaultRoles are created by EnsureRoles when missing.
+var defaultRoles = []struct {
+	nama, keterangan string
+	terbatasUnit     bool
+	izin             []string
+}{
+	{"admin", "Full access", false, []string{SemuaIzin}},
+	{"hr", "HR staff, maintains employees", false, []string{PegawaiRead, PegawaiWrite, PegawaiSensitif, LookupRead, AuditRead}},
+	{"lookup", "Maintains lookup data", false, []string{LookupRead, LookupWrite}},
+	{"viewer", "Read-only access", false, []string{PegawaiRead, LookupRead}},
+	{"kepala_unit", "Unit head, limited to the assigned units", true, []string{PegawaiRead, PegawaiWrite, LookupRead}},
+}
+
+// EnsureRoles creates the default roles that don't exist yet. Existing roles
+// are left alone, so their permissions can be changed.
+func EnsureRoles(db *gorm.DB) error {
+	for _, r := range defaultRoles {
+		role := Role{Nama: r.nama, Keterangan: r.keterangan, Terbatas_Unit: r.terbatasUnit}
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		for _, izin := range r.izin {
+			if err := db.Create(&RoleIzin{Role_ID: role.ID, Izin: izin}).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Akses is what the caller may do: permissions granted everywhere, and
+// permissions granted only for employees in Units.
+type Akses struct {
+	global   map[string]bool
+	terbatas map[string]bool
+	Units    []string
+}
+
+// Global reports whether izin is granted without unit restriction.
+func (a *Akses) Global(izin string) bool {
+	return a.global[SemuaIzin] || a.global[izin]
+}
+
+// Boleh reports whether izin is granted at all, possibly only for some units.
+func (a *Akses) Boleh(izin string) bool {
+	return a.Global(izin) || a.terbatas[izin]
+}
+
+// BolehUnit reports whether izin is granted for employees of unit.
+func (a *Akses) BolehUnit(izin, unit string) bool {
+	if a.Global(izin) {
+		return true
+	}
+	if !a.terbatas[izin] {
+		return false
+	}
+	for _, u := range a.Units {
+		if u == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// Scope returns the units izin is limited to; semua is true when it is not
+// limited. Queries listing employees should filter on the returned units
+// unless semua is set.
+func (a *Akses) Scope(izin string) (units []string, semua bool) {
+	if a.Global(izin) {
+		return nil, true
+	}
+	if !a.terbatas[izin] {
+		return []string{}, false
+	}
+	return a.Units, false
+}
+
+// aksesKey is the echo.Context key of the loaded Akses.
+const aksesKey = "auth.akses"
+
+// LoadAkses returns the Akses of the user.
+func LoadAkses(db *gorm.DB, userID int64) (*Akses, error) {
+	var rows []struct {
+		Izin          string
+		Terbatas_Unit bool
+	}
+	err := db.Table("user_role").
+		Select("role_izin.izin, role.terbatas_unit").
+		Joins("JOIN role ON role.id = user_role.role_id").
+		Joins("JOIN role_izin ON role_izin.role_id = role.id").
+		Where("user_role.user_id = ?", userID).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	akses := &Akses{global: map[string]bool{}, terbatas: map[string]bool{}}
+	for _, row := range rows {
+		if row.Terbatas_Unit {
+			akses.terbatas[row.Izin] = true
+		} else {
+			akses.global[row.Izin] = true
+		}
+	}
+	if len(akses.terbatas) > 0 {
+		if err := db.Model(&UserUnit{}).Where("user_id = ?", userID).Pluck("unit", &akses.Units).Error; err != nil {
+			return nil, err
+		}
+	}
+	return akses, nil
+}
+
+// AksesFrom returns the Akses of the authenticated caller, loading it once
+// per request. API keys have the permissions they were given; callers
+// without a token have none.
+func AksesFrom(db *gorm.DB, ctx echo.Context) (*Akses, error) {
+	if akses, ok := ctx.Get(aksesKey).(*Akses); ok {
+		return akses, nil
+	}
+	akses := &Akses{global: map[string]bool{}, terbatas: map[string]bool{}}
+	if apiKey := ApiKeyFromContext(ctx); apiKey != nil {
+		for _, izin := range apiKey.Izin {
+			akses.global[izin.Izin] = true
+		}
+	} else if claims := FromContext(ctx); claims != nil {
+		var err error
+		if akses, err = LoadAkses(db, claims.UserID()); err != nil {
+			return nil, err
+		}
+	}
+	ctx.Set(aksesKey, akses)
+	return akses, nil
+}
+
+// Require rejects callers that lack izin with 403. Permissions limited to
+// units pass; the handler must check the record's unit with BolehUnit or
+// filter with Scope.
+func Require(db *gorm.DB, izin string) echo.MiddlewareFunc {
+	return require(db, izin, (*Akses).Boleh)
+}
+
+// RequireGlobal is Require for actions that affect all units, which unit
+// limited permissions don't allow.
+func RequireGlobal(db *gorm.DB, izin string) echo.MiddlewareFunc {
+	return require(db, izin, (*Akses).Global)
+}
+
+func require(db *gorm.DB, izin string, check func(*Akses, string) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			akses, err := AksesFrom(db, ctx)
+			if err != nil {
+				return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to load permissions"})
+			}
+			if !check(akses, izin) {
+				return Forbidden(ctx)
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// RequireLookup guards a lookup service: reads need LookupRead, everything
+// else LookupWrite.
+func RequireLookup(db *gorm.DB) echo.MiddlewareFunc {
+	read, write := Require(db, LookupRead), Require(db, LookupWrite)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		readNext, writeNext := read(next), write(next)
+		return func(ctx echo.Context) error {
+			if publicPaths[ctx.Path()] {
+				return next(ctx)
+			}
+			switch ctx.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return readNext(ctx)
+			}
+			return writeNext(ctx)
+		}
+	}
+}
+
+// Forbidden writes the 403 response for a missing permission.
+func Forbidden(ctx echo.Context) error {
+	return ctx.JSON(http.StatusForbidden, map[string]string{"message": "You are not allowed to do this"})
+}
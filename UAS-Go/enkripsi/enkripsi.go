@@ -0,0 +1,168 @@
+// Package enkripsi encrypts sensitive fields at the application layer and
+// computes blind indexes, keyed hashes that allow exact-match lookups and
+// unique indexes on encrypted values.
+//
+// Keys are configured with ENKRIPSI_KEYS, a comma separated list of
+// "<id>:<base64 32-byte key>". The first key encrypts new values; the others
+// are only used to decrypt, so a key is rotated by putting a new one in front
+// and re-encrypting the stored values with "pegawai-api-2 rekey". Blind
+// indexes use ENKRIPSI_INDEX_KEY; changing it requires a rekey before the
+// indexes can be used again.
+//
+// Without ENKRIPSI_KEYS values are stored in plaintext, which is only meant
+// for development; existing plaintext values are read as they are.
+package enkripsi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// prefix starts every encrypted value: "enc:<key id>:<base64 nonce and
+// ciphertext>".
+const prefix = "enc:"
+
+// ErrKunci is returned for values encrypted with a key that isn't configured.
+var ErrKunci = errors.New("enkripsi: unknown key")
+
+type kunci struct {
+	id   string
+	aead cipher.AEAD
+}
+
+type konfigurasi struct {
+	aktif  *kunci
+	semua  map[string]*kunci
+	indeks []byte
+}
+
+// config reads the keys once. Invalid keys are a configuration error that
+// must not lead to unreadable data, so the process stops.
+var config = sync.OnceValue(func() *konfigurasi {
+	k, err := parseKonfigurasi(os.Getenv("ENKRIPSI_KEYS"), os.Getenv("ENKRIPSI_INDEX_KEY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if k.aktif == nil {
+		log.Print("enkripsi: ENKRIPSI_KEYS is not set, sensitive fields are stored in plaintext")
+	} else if k.indeks == nil {
+		log.Print("enkripsi: ENKRIPSI_INDEX_KEY is not set, blind indexes are unkeyed hashes")
+	}
+	return k
+})
+
+// parseKonfigurasi parses keys and index in the formats of ENKRIPSI_KEYS and
+// ENKRIPSI_INDEX_KEY.
+func parseKonfigurasi(keys, index string) (*konfigurasi, error) {
+	k := &konfigurasi{semua: make(map[string]*kunci)}
+	for _, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if !ok || id == "" || err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("enkripsi: ENKRIPSI_KEYS entry %q must be <id>:<base64 32-byte key>", id)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		key := &kunci{id: id, aead: aead}
+		if k.aktif == nil {
+			k.aktif = key
+		}
+		k.semua[id] = key
+	}
+
+	if index != "" {
+		raw, err := base64.StdEncoding.DecodeString(index)
+		if err != nil || len(raw) < 32 {
+			return nil, errors.New("enkripsi: ENKRIPSI_INDEX_KEY must be a base64 key of at least 32 bytes")
+		}
+		k.indeks = raw
+	}
+	return k, nil
+}
+
+// KunciAktif returns the ID of the key new values are encrypted with, or ""
+// when encryption is off.
+func KunciAktif() string {
+	if k := config().aktif; k != nil {
+		return k.id
+	}
+	return ""
+}
+
+// Enkripsi encrypts plaintext with the active key. Empty values stay empty.
+func Enkripsi(plaintext string) (string, error) {
+	k := config().aktif
+	if k == nil || plaintext == "" {
+		return plaintext, nil
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	// The key ID is authenticated, so a value can't be moved to another key
+	sealed := k.aead.Seal(nonce, nonce, []byte(plaintext), []byte(k.id))
+	return prefix + k.id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Dekripsi decrypts a value written by Enkripsi. Values without the prefix
+// were stored before encryption was enabled and are returned as they are.
+func Dekripsi(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", fmt.Errorf("enkripsi: malformed value")
+	}
+	k := config().semua[id]
+	if k == nil {
+		return "", fmt.Errorf("%w %q", ErrKunci, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return "", fmt.Errorf("enkripsi: malformed value")
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("enkripsi: value can't be decrypted with key %q", id)
+	}
+	return string(plaintext), nil
+}
+
+// BlindIndex returns the blind index of value for field, a hex HMAC-SHA256.
+// The field is part of the hash, so equal values of different fields can't
+// be linked. Empty values have no index.
+func BlindIndex(field, value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, config().indeks)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
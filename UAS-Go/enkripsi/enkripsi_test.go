@@ -0,0 +1,198 @@
+package enkripsi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// kunciTes returns a base64 32-byte key filled with b.
+func kunciTes(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// pakai configures keys and index for the rest of the test.
+func pakai(t *testing.T, keys, index string) {
+	t.Helper()
+	k, err := parseKonfigurasi(keys, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := config
+	config = func() *konfigurasi { return k }
+	t.Cleanup(func() { config = old })
+}
+
+// TestRekey rotates from key A to key B the way "pegawai-api-2 rekey" does:
+// read with both keys, write with the new one, then drop the old key.
+func TestRekey(t *testing.T) {
+	a, b := "a:"+kunciTes(1), "b:"+kunciTes(2)
+	const nik = "3201010101010001"
+
+	pakai(t, a, "")
+	lama, err := Enkripsi(nik)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pakai(t, b+","+a, "")
+	plaintext, err := Dekripsi(lama)
+	if err != nil {
+		t.Fatalf("decrypt with a after rotation: %v", err)
+	}
+	baru, err := Kolom(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pakai(t, b, "")
+	if got, err := Dekripsi(baru.(string)); err != nil || got != nik {
+		t.Errorf("Dekripsi(rekeyed) = %q, %v; want %q", got, err, nik)
+	}
+	if _, err := Dekripsi(lama); !errors.Is(err, ErrKunci) {
+		t.Errorf("Dekripsi(old value) error = %v; want ErrKunci", err)
+	}
+}
+
+func TestEnkripsi(t *testing.T) {
+	pakai(t, "a:"+kunciTes(1), "")
+	for _, plaintext := range []string{"", "3201010101010001", "Jl. Merdeka No. 1, Bandung"} {
+		value, err := Enkripsi(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plaintext != "" && (value == plaintext || value[:len(prefix)+2] != prefix+"a:") {
+			t.Errorf("Enkripsi(%q) = %q; want a value encrypted with key a", plaintext, value)
+		}
+		if got, err := Dekripsi(value); err != nil || got != plaintext {
+			t.Errorf("Dekripsi(Enkripsi(%q)) = %q, %v", plaintext, got, err)
+		}
+	}
+
+	a, _ := Enkripsi("3201010101010001")
+	b, _ := Enkripsi("3201010101010001")
+	if a == b {
+		t.Error("Enkripsi returned the same value twice; the nonce must be random")
+	}
+}
+
+func TestDekripsi(t *testing.T) {
+	pakai(t, "a:"+kunciTes(1)+",b:"+kunciTes(2), "")
+	sah, err := Enkripsi("3201010101010001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr error
+	}{
+		{"encrypted", sah, "3201010101010001", nil},
+		{"plaintext from before encryption", "3201010101010001", "3201010101010001", nil},
+		{"unknown key", "enc:c:AAAA", "", ErrKunci},
+		{"missing key id", "enc:AAAA", "", errMalformed},
+		{"not base64", "enc:a:!!!!", "", errMalformed},
+		{"too short", "enc:a:AAAA", "", errMalformed},
+		// The key ID is authenticated, so relabelling a value fails
+		{"moved to another key", "enc:b:" + sah[len("enc:a:"):], "", errMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Dekripsi(tt.value)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("Dekripsi() error = %v", err)
+			case tt.wantErr == ErrKunci && !errors.Is(err, ErrKunci):
+				t.Fatalf("Dekripsi() error = %v; want ErrKunci", err)
+			case tt.wantErr == errMalformed && (err == nil || errors.Is(err, ErrKunci)):
+				t.Fatalf("Dekripsi() error = %v; want a malformed value", err)
+			}
+			if got != tt.want {
+				t.Errorf("Dekripsi() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// errMalformed marks the cases TestDekripsi expects to fail for any reason
+// but an unknown key.
+var errMalformed = errors.New("malformed")
+
+func TestDekripsiTanpaKunci(t *testing.T) {
+	pakai(t, "", "")
+	if got, err := Enkripsi("3201010101010001"); err != nil || got != "3201010101010001" {
+		t.Errorf("Enkripsi() without keys = %q, %v; want the plaintext", got, err)
+	}
+	if _, err := Dekripsi("enc:a:AAAA"); !errors.Is(err, ErrKunci) {
+		t.Errorf("Dekripsi() without keys error = %v; want ErrKunci", err)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	pakai(t, "", kunciTes(3))
+	nik := BlindIndex("nik", "3201010101010001")
+	if len(nik) != 64 {
+		t.Fatalf("BlindIndex() = %q; want a hex SHA-256", nik)
+	}
+	tests := []struct {
+		name  string
+		field string
+		value string
+		same  bool
+	}{
+		{"deterministic", "nik", "3201010101010001", true},
+		{"surrounding space ignored", "nik", " 3201010101010001\n", true},
+		{"other value", "nik", "3201010101010002", false},
+		{"other field", "npwp", "3201010101010001", false},
+	}
+	for _, tt := range tests {
+		if got := BlindIndex(tt.field, tt.value); (got == nik) != tt.same {
+			t.Errorf("%s: BlindIndex(%q, %q) = %q, same as nik index %v; want %v", tt.name, tt.field, tt.value, got, got == nik, tt.same)
+		}
+	}
+	if got := BlindIndex("nik", " "); got != "" {
+		t.Errorf("BlindIndex() of a blank value = %q; want empty", got)
+	}
+
+	pakai(t, "", kunciTes(4))
+	if BlindIndex("nik", "3201010101010001") == nik {
+		t.Error("BlindIndex() doesn't depend on ENKRIPSI_INDEX_KEY")
+	}
+}
+
+func TestParseKonfigurasi(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    string
+		index   string
+		aktif   string
+		wantErr bool
+	}{
+		{"none", "", "", "", false},
+		{"first key is active", "b:" + kunciTes(2) + ", a:" + kunciTes(1), kunciTes(3), "b", false},
+		{"missing id", ":" + kunciTes(1), "", "", true},
+		{"missing key", "a", "", "", true},
+		{"short key", "a:" + base64.StdEncoding.EncodeToString([]byte("short")), "", "", true},
+		{"short index key", "", base64.StdEncoding.EncodeToString([]byte("short")), "", true},
+		{"index key not base64", "", "!!!", "", true},
+	}
+	for _, tt := range tests {
+		k, err := parseKonfigurasi(tt.keys, tt.index)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseKonfigurasi() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var aktif string
+		if k.aktif != nil {
+			aktif = k.aktif.id
+		}
+		if aktif != tt.aktif {
+			t.Errorf("%s: active key = %q; want %q", tt.name, aktif, tt.aktif)
+		}
+	}
+}
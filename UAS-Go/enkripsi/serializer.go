@@ -0,0 +1,54 @@
+package enkripsi
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("enkripsi", Serializer{})
+}
+
+// Serializer stores string fields tagged `gorm:"serializer:enkripsi"`
+// encrypted, and decrypts them when they are read. Empty strings are stored
+// as NULL. Queries can't compare encrypted columns; use a blind index column
+// instead.
+type Serializer struct{}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("enkripsi: unsupported value %T for %s", dbValue, field.Name)
+	}
+	plaintext, err := Dekripsi(value)
+	if err != nil {
+		return err
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return fieldValue, nil
+	}
+	return Kolom(value)
+}
+
+// Kolom returns the column value of a field stored with Serializer. GORM
+// doesn't apply serializers to map updates, which must use Kolom instead.
+func Kolom(value string) (interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+	return Enkripsi(value)
+}
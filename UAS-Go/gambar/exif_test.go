@@ -0,0 +1,113 @@
+package gambar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// segmen returns a JPEG marker segment with its length.
+func segmen(marker byte, data []byte) []byte {
+	s := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(s[2:], uint16(len(data)+2))
+	return append(s, data...)
+}
+
+// exif returns an APP1 segment whose IFD0 holds the orientation tag.
+func exif(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112) // tag
+	order.PutUint16(tiff[12:], 3)      // SHORT
+	order.PutUint32(tiff[14:], 1)      // count
+	order.PutUint16(tiff[18:], orientation)
+	return segmen(0xE1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+func jpegDari(segments ...[]byte) []byte {
+	data := []byte{0xFF, 0xD8}
+	for _, s := range segments {
+		data = append(data, s...)
+	}
+	return append(data, 0xFF, 0xD9)
+}
+
+func TestExifOrientation(t *testing.T) {
+	var asli bytes.Buffer
+	if err := jpeg.Encode(&asli, image.NewGray(image.Rect(0, 0, 2, 2)), nil); err != nil {
+		t.Fatal(err)
+	}
+	app0 := segmen(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+	sos := segmen(0xDA, []byte{0})
+	terpotong := exif(binary.LittleEndian, 6)
+	terpotong = terpotong[:len(terpotong)-8]
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"empty", nil, 1},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"JPEG without EXIF", asli.Bytes(), 1},
+		{"little endian", jpegDari(exif(binary.LittleEndian, 6)), 6},
+		{"big endian", jpegDari(exif(binary.BigEndian, 3)), 3},
+		{"after APP0", jpegDari(app0, exif(binary.BigEndian, 8)), 8},
+		{"after start of scan", jpegDari(sos, exif(binary.BigEndian, 8)), 1},
+		{"out of range", jpegDari(exif(binary.LittleEndian, 9)), 1},
+		{"truncated segment", append([]byte{0xFF, 0xD8}, terpotong...), 1},
+	}
+	for _, tt := range tests {
+		if got := exifOrientation(tt.data); got != tt.want {
+			t.Errorf("%s: exifOrientation() = %d; want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image whose top-left pixel is red and top-right pixel is blue.
+	const w, h = 3, 2
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	kiriAtas := color.RGBA{R: 255, A: 255}
+	kananAtas := color.RGBA{B: 255, A: 255}
+	src.Set(0, 0, kiriAtas)
+	src.Set(w-1, 0, kananAtas)
+
+	tests := []struct {
+		orientation         int
+		width, height       int
+		kiriAtas, kananAtas image.Point // where the source corners end up
+	}{
+		{1, w, h, image.Pt(0, 0), image.Pt(w-1, 0)},
+		{2, w, h, image.Pt(w-1, 0), image.Pt(0, 0)},
+		{3, w, h, image.Pt(w-1, h-1), image.Pt(0, h-1)},
+		{4, w, h, image.Pt(0, h-1), image.Pt(w-1, h-1)},
+		{5, h, w, image.Pt(0, 0), image.Pt(0, w-1)},
+		{6, h, w, image.Pt(h-1, 0), image.Pt(h-1, w-1)},
+		{7, h, w, image.Pt(h-1, w-1), image.Pt(h-1, 0)},
+		{8, h, w, image.Pt(0, w-1), image.Pt(0, 0)},
+	}
+	for _, tt := range tests {
+		got := orient(src, tt.orientation)
+		if b := got.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("orient(%d) size = %dx%d; want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.width, tt.height)
+			continue
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.kiriAtas.X, tt.kiriAtas.Y)); c != kiriAtas {
+			t.Errorf("orient(%d) at %v = %v; want the top-left pixel", tt.orientation, tt.kiriAtas, c)
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.kananAtas.X, tt.kananAtas.Y)); c != kananAtas {
+			t.Errorf("orient(%d) at %v = %v; want the top-right pixel", tt.orientation, tt.kananAtas, c)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Package gambar validates and normalises uploaded employee photos. Uploads
+// are content-sniffed, limited in size, rotated according to their EXIF
+// orientation, cropped and resized to a passport-photo ratio and re-encoded
+// as JPEG, which also drops any EXIF metadata. Smaller variants are stored
+// next to the photo for thumbnails.
+package gambar
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"uas/storage"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrInvalid is wrapped by every error caused by the upload itself rather
+// than by the server, so handlers can answer with 400.
+var ErrInvalid = errors.New("invalid image")
+
+// Original is the size name of the full, normalised photo.
+const Original = "original"
+
+// ContentType is the type of every stored photo and variant.
+const ContentType = "image/jpeg"
+
+// minSide is the smallest accepted width and height in pixels.
+const minSide = 64
+
+// allowed lists the sniffed content types that are accepted.
+var allowed = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// Config controls the processing. It is read from the environment once by
+// DefaultConfig.
+type Config struct {
+	MaxBytes  int64          // largest accepted upload
+	MaxPixels int            // largest accepted width*height, guards against decompression bombs
+	Width     int            // width of the stored photo
+	Height    int            // height of the stored photo; Width:Height is the crop ratio
+	Quality   int            // JPEG quality
+	Sizes     map[string]int // variant name -> width, the height follows the ratio
+}
+
+// DefaultConfig is read from the environment:
+//
+//	GAMBAR_MAX_BYTES   largest upload in bytes (default 5 MiB)
+//	GAMBAR_UKURAN      size of the stored photo as WxH (default 600x800, i.e. 3:4)
+//	GAMBAR_KUALITAS    JPEG quality (default 85)
+//	GAMBAR_THUMBNAIL   variants as name:width pairs (default "thumb:150,medium:300")
+var DefaultConfig = configFromEnv()
+
+func configFromEnv() Config {
+	cfg := Config{
+		MaxBytes:  5 << 20,
+		MaxPixels: 40_000_000,
+		Width:     600,
+		Height:    800,
+		Quality:   85,
+		Sizes:     map[string]int{"thumb": 150, "medium": 300},
+	}
+	if n, err := strconv.ParseInt(os.Getenv("GAMBAR_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		cfg.MaxBytes = n
+	}
+	if w, h, ok := strings.Cut(os.Getenv("GAMBAR_UKURAN"), "x"); ok {
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if errW == nil && errH == nil && width > 0 && height > 0 {
+			cfg.Width, cfg.Height = width, height
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("GAMBAR_KUALITAS")); err == nil && n >= 1 && n <= 100 {
+		cfg.Quality = n
+	}
+	if value := os.Getenv("GAMBAR_THUMBNAIL"); value != "" {
+		sizes := make(map[string]int)
+		for _, pair := range strings.Split(value, ",") {
+			name, width, _ := strings.Cut(strings.TrimSpace(pair), ":")
+			n, err := strconv.Atoi(width)
+			if name == "" || name == Original || err != nil || n <= 0 {
+				continue
+			}
+			sizes[name] = n
+		}
+		cfg.Sizes = sizes
+	}
+	return cfg
+}
+
+// SizeNames returns the variant names, plus Original, in a stable order.
+func SizeNames() []string {
+	names := make([]string, 0, len(DefaultConfig.Sizes)+1)
+	for name := range DefaultConfig.Sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append(names, Original)
+}
+
+// Key returns the storage name of the given size of the photo name, and
+// whether the size is known. Variants live under "thumbnail/<size>/".
+func Key(name, size string) (string, bool) {
+	if size == "" || size == Original {
+		return name, true
+	}
+	if _, ok := DefaultConfig.Sizes[size]; !ok {
+		return "", false
+	}
+	return path.Join("thumbnail", size, name), true
+}
+
+// Save processes the uploaded file and stores the photo and its variants.
+// It returns the generated name of the photo; the uploaded file name is
+// never used.
+func Save(ctx context.Context, store storage.Storage, file *multipart.FileHeader) (string, error) {
+	staged, err := Stage(ctx, store, file)
+	if err != nil {
+		return "", err
+	}
+	if err := staged.Commit(ctx); err != nil {
+		return "", err
+	}
+	return staged.Name, nil
+}
+
+// SaveReader is Save for an image read from r.
+func SaveReader(ctx context.Context, store storage.Storage, r io.Reader) (string, error) {
+	staged, err := StageReader(ctx, store, r)
+	if err != nil {
+		return "", err
+	}
+	if err := staged.Commit(ctx); err != nil {
+		return "", err
+	}
+	return staged.Name, nil
+}
+
+// StagingPrefix holds processed photos that are not committed yet. Files left
+// there by a crash are removed by the garbage collector.
+const StagingPrefix = "staging"
+
+// Staged is a processed photo waiting in the staging area. Commit it once
+// the database change referencing Name has succeeded, or Discard it.
+type Staged struct {
+	Name  string
+	store storage.Storage
+	keys  map[string]string // staging key -> final key
+}
+
+// Stage processes the uploaded file into the staging area.
+func Stage(ctx context.Context, store storage.Storage, file *multipart.FileHeader) (*Staged, error) {
+	if file.Size > DefaultConfig.MaxBytes {
+		return nil, tooLarge(DefaultConfig.MaxBytes)
+	}
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return StageReader(ctx, store, src)
+}
+
+// StageReader is Stage for an image read from r.
+func StageReader(ctx context.Context, store storage.Storage, r io.Reader) (*Staged, error) {
+	encoded, err := Process(r, DefaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	name, err := newName()
+	if err != nil {
+		return nil, err
+	}
+
+	staged := &Staged{Name: name, store: store, keys: make(map[string]string, len(encoded))}
+	for size, data := range encoded {
+		key, _ := Key(name, size)
+		tmp := path.Join(StagingPrefix, key)
+		if err := store.Save(ctx, tmp, bytes.NewReader(data), int64(len(data)), ContentType); err != nil {
+			staged.Discard(ctx)
+			return nil, err
+		}
+		staged.keys[tmp] = key
+	}
+	return staged, nil
+}
+
+// Commit moves the staged files to their final names. Variants are moved
+// before the photo itself, so a photo never exists without its variants.
+func (s *Staged) Commit(ctx context.Context) error {
+	for tmp, key := range s.keys {
+		if key == s.Name {
+			continue
+		}
+		if err := s.store.Move(ctx, tmp, key); err != nil {
+			return err
+		}
+	}
+	return s.store.Move(ctx, path.Join(StagingPrefix, s.Name), s.Name)
+}
+
+// Discard removes the staged files. It is safe to call after a failed
+// Commit; files already moved are left for the garbage collector.
+func (s *Staged) Discard(ctx context.Context) {
+	for tmp := range s.keys {
+		s.store.Delete(ctx, tmp)
+	}
+}
+
+// Delete removes the photo name and its variants. Variants are optional, as
+// photos uploaded before they existed have none.
+func Delete(ctx context.Context, store storage.Storage, name string) error {
+	for size := range DefaultConfig.Sizes {
+		key, _ := Key(name, size)
+		if err := store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return err
+		}
+	}
+	return store.Delete(ctx, name)
+}
+
+// Process validates the image read from r and returns the encoded photo and
+// variants keyed by size name.
+func Process(r io.Reader, cfg Config) (map[string][]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, cfg.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > cfg.MaxBytes {
+		return nil, tooLarge(cfg.MaxBytes)
+	}
+
+	// Trust the content, not the file name or the client's Content-Type.
+	if contentType := http.DetectContentType(data); !allowed[contentType] {
+		return nil, fmt.Errorf("%w: only JPEG, PNG and WebP are accepted, got %s", ErrInvalid, contentType)
+	}
+	conf, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if conf.Width < minSide || conf.Height < minSide {
+		return nil, fmt.Errorf("%w: image must be at least %dx%d pixels", ErrInvalid, minSide, minSide)
+	}
+	if conf.Width*conf.Height > cfg.MaxPixels {
+		return nil, fmt.Errorf("%w: image dimensions %dx%d are too large", ErrInvalid, conf.Width, conf.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	img = orient(img, exifOrientation(data))
+	img = cropToRatio(img, cfg.Width, cfg.Height)
+
+	encoded := make(map[string][]byte, len(cfg.Sizes)+1)
+	if encoded[Original], err = encode(img, cfg.Width, cfg.Height, cfg.Quality); err != nil {
+		return nil, err
+	}
+	for size, width := range cfg.Sizes {
+		height := width * cfg.Height / cfg.Width
+		if encoded[size], err = encode(img, width, height, cfg.Quality); err != nil {
+			return nil, err
+		}
+	}
+	return encoded, nil
+}
+
+func tooLarge(max int64) error {
+	return fmt.Errorf("%w: file is larger than %d bytes", ErrInvalid, max)
+}
+
+func newName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b) + ".jpg", nil
+}
+
+// cropToRatio cuts the largest centred width:height rectangle out of img.
+func cropToRatio(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	crop := b
+	if b.Dx()*height > b.Dy()*width {
+		w := b.Dy() * width / height
+		crop.Min.X = b.Min.X + (b.Dx()-w)/2
+		crop.Max.X = crop.Min.X + w
+	} else {
+		h := b.Dx() * height / width
+		crop.Min.Y = b.Min.Y + (b.Dy()-h)/2
+		crop.Max.Y = crop.Min.Y + h
+	}
+
+	// Copy onto white so transparent PNG/WebP areas don't turn black in JPEG.
+	dst := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, crop.Min, draw.Over)
+	return dst
+}
+
+// encode scales img to width x height and encodes it as JPEG.
+func encode(img image.Image, width, height, quality int) ([]byte, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,62 @@
+package gambar
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCropToRatio(t *testing.T) {
+	merah := color.RGBA{R: 255, A: 255}
+	hijau := color.RGBA{G: 255, A: 255}
+	putih := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+
+	// banner is a 400x200 image with a green 200x200 square in the middle.
+	banner := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			if x >= 100 && x < 300 {
+				banner.Set(x, y, hijau)
+			} else {
+				banner.Set(x, y, merah)
+			}
+		}
+	}
+	// tegak is a 100x300 image with a green 100x100 square in the middle.
+	tegak := image.NewRGBA(image.Rect(0, 0, 100, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 100; x++ {
+			if y >= 100 && y < 200 {
+				tegak.Set(x, y, hijau)
+			} else {
+				tegak.Set(x, y, merah)
+			}
+		}
+	}
+
+	tests := []struct {
+		name          string
+		img           image.Image
+		width, height int
+		wantW, wantH  int
+		want          color.RGBA // every pixel of the result
+	}{
+		{"wide to square", banner, 1, 1, 200, 200, hijau},
+		{"tall to square", tegak, 1, 1, 100, 100, hijau},
+		{"offset bounds", banner.SubImage(image.Rect(50, 0, 350, 200)), 1, 1, 200, 200, hijau},
+		{"transparent becomes white", image.NewNRGBA(image.Rect(0, 0, 30, 40)), 3, 4, 30, 40, putih},
+	}
+	for _, tt := range tests {
+		got := cropToRatio(tt.img, tt.width, tt.height)
+		b := got.Bounds()
+		if b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("%s: size = %dx%d; want %dx%d", tt.name, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			continue
+		}
+		for _, p := range []image.Point{b.Min, {b.Max.X - 1, b.Min.Y}, {b.Min.X, b.Max.Y - 1}, b.Max.Sub(image.Pt(1, 1))} {
+			if c := color.RGBAModel.Convert(got.At(p.X, p.Y)); c != tt.want {
+				t.Errorf("%s: pixel %v = %v; want %v", tt.name, p, c, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package gambar
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"uas/storage"
+)
+
+// GC removes photos, variants and staged files that are not referenced.
+// referenced holds the photo names in use. Files younger than minAge are
+// kept, as they may belong to a request that is still running. Files under
+// other prefixes, such as documents, are left alone. With dryRun set nothing
+// is deleted. It returns the names of the orphaned files.
+func GC(ctx context.Context, store storage.Storage, referenced map[string]bool, minAge time.Duration, dryRun bool) ([]string, error) {
+	names, err := store.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []string
+	for _, name := range names {
+		if !isOrphan(name, referenced) {
+			continue
+		}
+		info, err := store.Stat(ctx, name)
+		if errors.Is(err, storage.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return orphans, err
+		}
+		if time.Since(info.ModTime) < minAge {
+			continue
+		}
+
+		orphans = append(orphans, name)
+		if dryRun {
+			continue
+		}
+		if err := store.Delete(ctx, name); err != nil && !errors.Is(err, storage.ErrNotExist) {
+			log.Printf("gambar: failed to remove %s: %v", name, err)
+		}
+	}
+	return orphans, nil
+}
+
+// isOrphan reports whether the stored file name is a photo file that no
+// Pegawai references.
+func isOrphan(name string, referenced map[string]bool) bool {
+	dir, file := path.Split(name)
+	switch {
+	case strings.HasPrefix(name, StagingPrefix+"/"):
+		return true
+	case dir == "":
+		return !referenced[file]
+	case strings.HasPrefix(dir, "thumbnail/") && strings.Count(dir, "/") == 2:
+		return !referenced[file]
+	}
+	return false
+}
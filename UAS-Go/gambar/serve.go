@@ -0,0 +1,117 @@
+package gambar
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"uas/storage"
+)
+
+// ErrSize is returned by Serve for an unknown size name.
+var ErrSize = errors.New("unknown image size")
+
+// Serve writes the given size of the photo name to w. It sets Content-Type,
+// ETag and Cache-Control and lets http.ServeContent answer conditional and
+// Range requests. Photos uploaded before variants existed fall back to the
+// original. A missing file is reported as storage.ErrNotExist.
+func Serve(w http.ResponseWriter, r *http.Request, store storage.Storage, name, size string) error {
+	key, ok := Key(name, size)
+	if !ok {
+		return ErrSize
+	}
+	f, err := store.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotExist) && key != name {
+		f, err = store.Open(r.Context(), name)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := w.Header()
+	if contentType := mime.TypeByExtension(path.Ext(info.Name)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	} else if info.ContentType != "" {
+		header.Set("Content-Type", info.ContentType)
+	}
+	// Stored names are generated and never rewritten, so name, size and
+	// modification time identify the content.
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", info.Name, info.Size, info.ModTime.UnixNano())))
+	header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	header.Set("Cache-Control", "private, max-age=300")
+
+	http.ServeContent(w, r, "", info.ModTime, f)
+	return nil
+}
+
+// urlSecret signs photo URLs. It is read from GAMBAR_URL_SECRET; without it a
+// random secret is used, so signed URLs stop working after a restart and are
+// not shared between instances.
+var urlSecret = sync.OnceValue(loadURLSecret)
+
+func loadURLSecret() []byte {
+	if secret := os.Getenv("GAMBAR_URL_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	log.Print("gambar: GAMBAR_URL_SECRET is not set, signed photo URLs will not survive a restart")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+func signature(id, size string, expires int64) string {
+	if size == "" {
+		size = Original
+	}
+	mac := hmac.New(sha256.New, urlSecret())
+	fmt.Fprintf(mac, "%s:%s:%d", id, size, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedQuery returns the query string granting access to the given size of
+// the photo of the Pegawai id until expires.
+func SignedQuery(id, size string, expires time.Time) string {
+	q := url.Values{}
+	if size != "" {
+		q.Set("size", size)
+	}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("signature", signature(id, size, expires.Unix()))
+	return q.Encode()
+}
+
+// Signed reports whether the query carries a signature.
+func Signed(q url.Values) bool {
+	return q.Has("signature")
+}
+
+// Verify checks the expires and signature parameters of q for the photo of the
+// Pegawai id.
+func Verify(id string, q url.Values) bool {
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	want := signature(id, q.Get("size"), expires)
+	return hmac.Equal([]byte(want), []byte(q.Get("signature")))
+}
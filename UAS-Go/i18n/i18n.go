@@ -0,0 +1,208 @@
+// Package i18n stores translated labels for lookup rows and picks the locales
+// a request accepts. Labels stored on the lookup rows themselves are
+// Indonesian, which is also the fallback when no translation exists.
+package i18n
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultLocale is the locale of the labels stored on the lookup rows.
+const DefaultLocale = "id"
+
+// Terjemahan is the label of one lookup row in one locale. Lookup names the
+// lookup table, e.g. "agama".
+type Terjemahan struct {
+	ID        int64     `json:"id"`
+	Lookup    string    `gorm:"size:50;uniqueIndex:idx_terjemahan" json:"lookup"`
+	Lookup_ID int64     `gorm:"uniqueIndex:idx_terjemahan" json:"lookup_id"`
+	Locale    string    `gorm:"size:10;uniqueIndex:idx_terjemahan" json:"locale"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Terjemahan) TableName() string {
+	return "terjemahan_lookup"
+}
+
+// Locales returns the locales a request accepts, most preferred first: the
+// one requested with ?lang=, or else the languages of the Accept-Language
+// header by weight. Only the primary language subtag is used, so "en-US"
+// selects "en". Labels depend on the header, so Locales adds it to Vary.
+func Locales(ctx echo.Context) []string {
+	ctx.Response().Header().Add(echo.HeaderVary, "Accept-Language")
+	if lang := ctx.QueryParam("lang"); lang != "" {
+		return []string{primary(lang)}
+	}
+
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(ctx.Request().Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if fields[0] == "" || fields[0] == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, weighted{primary(fields[0]), q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	locales := make([]string, 0, len(langs))
+	seen := make(map[string]bool, len(langs))
+	for _, l := range langs {
+		if !seen[l.lang] {
+			seen[l.lang] = true
+			locales = append(locales, l.lang)
+		}
+	}
+	return locales
+}
+
+func primary(tag string) string {
+	tag, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	return tag
+}
+
+// Apply replaces the label of every row with its translation in the most
+// preferred of locales that has one. The field function returns the ID of a
+// row and a pointer to its label. Rows without a translation in any locale
+// preferred over DefaultLocale keep their Indonesian label.
+func Apply[T any](db *gorm.DB, lookup string, locales []string, rows []T, field func(T) (int64, *string)) error {
+	// Every row has a DefaultLocale label, so less preferred locales never apply
+	for i, locale := range locales {
+		if locale == DefaultLocale {
+			locales = locales[:i]
+			break
+		}
+	}
+	if len(locales) == 0 || len(rows) == 0 {
+		return nil
+	}
+	rank := make(map[string]int, len(locales))
+	for i, locale := range locales {
+		rank[locale] = i
+	}
+	ids := make([]int64, len(rows))
+	for i, row := range rows {
+		ids[i], _ = field(row)
+	}
+
+	terjemahan := make([]*Terjemahan, 0)
+	err := db.Where("lookup = ? AND locale IN ? AND lookup_id IN ?", lookup, locales, ids).Find(&terjemahan).Error
+	if err != nil {
+		return err
+	}
+	best := make(map[int64]*Terjemahan, len(terjemahan))
+	for _, t := range terjemahan {
+		if b, ok := best[t.Lookup_ID]; !ok || rank[t.Locale] < rank[b.Locale] {
+			best[t.Lookup_ID] = t
+		}
+	}
+	for _, row := range rows {
+		id, label := field(row)
+		if t, ok := best[id]; ok {
+			*label = t.Label
+		}
+	}
+	return nil
+}
+
+// TerjemahanHandler manages the translations of one lookup table.
+type TerjemahanHandler struct {
+	db     *gorm.DB
+	lookup string
+}
+
+func NewTerjemahanHandler(db *gorm.DB, lookup string) *TerjemahanHandler {
+	return &TerjemahanHandler{db: db, lookup: lookup}
+}
+
+type TerjemahanRequest struct {
+	Lookup_ID string `param:"id"`
+	Locale    string `param:"locale"`
+	Label     string `json:"label"`
+}
+
+func (h *TerjemahanHandler) GetAllTerjemahan(ctx echo.Context) error {
+	var input TerjemahanRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	terjemahan := make([]*Terjemahan, 0)
+	if err := h.db.Where("lookup = ? AND lookup_id = ?", h.lookup, input.Lookup_ID).Order("locale").Find(&terjemahan).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Terjemahan"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Terjemahan", "data": terjemahan})
+}
+
+// SetTerjemahan creates or replaces the translation for one locale.
+func (h *TerjemahanHandler) SetTerjemahan(ctx echo.Context) error {
+	var input TerjemahanRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	locale := primary(input.Locale)
+	if locale == "" || locale == DefaultLocale {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Locale must not be empty or " + DefaultLocale + "; edit the lookup itself instead"})
+	}
+	if input.Label == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "label is required"})
+	}
+	lookupID, err := strconv.ParseInt(input.Lookup_ID, 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid ID"})
+	}
+	var count int64
+	if err := h.db.Table(h.lookup).Where("id = ?", lookupID).Count(&count).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Set Terjemahan"})
+	}
+	if count == 0 {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": fmt.Sprintf("%s %d not found", h.lookup, lookupID)})
+	}
+
+	terjemahan := &Terjemahan{Lookup: h.lookup, Lookup_ID: lookupID, Locale: locale, Label: input.Label}
+	err = h.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "lookup"}, {Name: "lookup_id"}, {Name: "locale"}},
+		DoUpdates: clause.AssignmentColumns([]string{"label", "updated_at"}),
+	}).Create(terjemahan).Error
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Set Terjemahan"})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Set Terjemahan", "data": terjemahan})
+}
+
+func (h *TerjemahanHandler) DeleteTerjemahan(ctx echo.Context) error {
+	var input TerjemahanRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	err := h.db.Where("lookup = ? AND lookup_id = ? AND locale = ?", h.lookup, input.Lookup_ID, primary(input.Locale)).Delete(&Terjemahan{}).Error
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Delete Terjemahan"})
+	}
+	return ctx.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,45 @@
+package i18n
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestLocales(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		want           []string
+	}{
+		{"none", "", "", []string{}},
+		{"query", "?lang=en-GB", "id", []string{"en"}},
+		{"header", "", "en-US", []string{"en"}},
+		{"sorted by q", "", "id;q=0.5, ja, en;q=0.9", []string{"ja", "en", "id"}},
+		{"order breaks ties", "", "ja, en", []string{"ja", "en"}},
+		{"duplicates dropped", "", "en-US, en;q=0.9, id;q=0.8", []string{"en", "id"}},
+		{"wildcard ignored", "", "*, en;q=0.1", []string{"en"}},
+		{"q=0 excluded", "", "en;q=0, id;q=0.2", []string{"id"}},
+	}
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/agama"+tt.query, nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			rec := httptest.NewRecorder()
+			ctx := e.NewContext(req, rec)
+			if got := Locales(ctx); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Locales() = %q; want %q", got, tt.want)
+			}
+			if vary := rec.Header().Get(echo.HeaderVary); vary != "Accept-Language" {
+				t.Errorf("Vary = %q; want Accept-Language", vary)
+			}
+		})
+	}
+}
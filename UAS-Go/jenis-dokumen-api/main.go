@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"uas/audit"
+	"uas/auth"
+	"uas/i18n"
+	"uas/keamanan"
+	"uas/ratelimit"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func initDB() (*gorm.DB, error) {
+	dsn := "root:@tcp(127.0.0.1:3306)/acrud?charset=utf8mb4&parseTime=True&loc=Local"
+	newLogger := logger.New(
+		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
+		logger.Config{
+			SlowThreshold:             time.Second, // Slow SQL threshold
+			LogLevel:                  logger.Info, // Log level
+			IgnoreRecordNotFoundError: true,        // Ignore ErrRecordNotFound error for logger
+			ParameterizedQueries:      false,       // Don't include params in the SQL log
+			Colorful:                  true,        // Disable color
+		},
+	)
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: newLogger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = db.AutoMigrate(&JenisDokumen{}, &i18n.Terjemahan{}, &auth.TokenDicabut{}, &audit.Log{})
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func main() {
+	// initialisasi database
+	db, err := initDB()
+	if err != nil {
+		panic(err)
+	}
+	limiter, err := ratelimit.FromEnv(db)
+	if err != nil {
+		panic(err)
+	}
+	keamananConfig, err := keamanan.FromEnv()
+	if err != nil {
+		panic(err)
+	}
+	// inisialisasi handler
+	jenisDokumenHandler := NewJenisDokumenHandler(db)
+	terjemahanHandler := i18n.NewTerjemahanHandler(db, "jenis_dokumen")
+
+	e := echo.New()
+	e.IPExtractor = limiter.IPExtractor()
+	e.Use(middleware.RequestID())
+	e.Use(keamanan.Middleware(keamananConfig))
+	e.Use(limiter.PerIP(ratelimit.GroupIP))
+	e.Use(auth.Middleware(db))
+	e.Use(limiter.PerClient(ratelimit.GroupDefault))
+	e.Use(auth.RequireLookup(db))
+	// routing
+	e.GET("/jenisdokumen", jenisDokumenHandler.GetAllJenisDokumen)
+	e.GET("/jenisdokumen/:id", jenisDokumenHandler.GetJenisDokumenByID)
+	e.POST("/jenisdokumen", jenisDokumenHandler.CreateJenisDokumen)
+	e.PUT("/jenisdokumen/:id", jenisDokumenHandler.UpdateJenisDokumen)
+	e.DELETE("/jenisdokumen/:id", jenisDokumenHandler.DeleteJenisDokumen)
+	e.GET("/jenisdokumen/:id/terjemahan", terjemahanHandler.GetAllTerjemahan)
+	e.PUT("/jenisdokumen/:id/terjemahan/:locale", terjemahanHandler.SetTerjemahan)
+	e.DELETE("/jenisdokumen/:id/terjemahan/:locale", terjemahanHandler.DeleteTerjemahan)
+	e.Logger.Fatal(e.Start(":1882"))
+}
+
+// JenisDokumen is a kind of document kept for employees, e.g. KTP or ijazah.
+type JenisDokumen struct {
+	ID            int64     `json:"id"`
+	Jenis_Dokumen string    `json:"jenis_dokumen"`
+	Kode          string    `gorm:"size:20" json:"kode"`
+	Urutan        int       `json:"urutan"`
+	Aktif         bool      `gorm:"default:true" json:"aktif"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (JenisDokumen) TableName() string {
+	return "jenis_dokumen"
+}
+
+type JenisDokumenHandler struct {
+	db *gorm.DB
+}
+
+func NewJenisDokumenHandler(db *gorm.DB) *JenisDokumenHandler {
+	return &JenisDokumenHandler{db: db}
+}
+
+type JenisDokumenRequest struct {
+	ID            string `param:"id"`
+	Jenis_Dokumen string `json:"jenis_dokumen"`
+	Kode          string `json:"kode"`
+	Urutan        int    `json:"urutan"`
+	Aktif         *bool  `json:"aktif"`
+}
+
+func (h *JenisDokumenHandler) GetAllJenisDokumen(ctx echo.Context) error {
+	search := ctx.QueryParam("search")
+	jenisdokumen := make([]*JenisDokumen, 0)
+	query := h.db.Model(&JenisDokumen{}).Order("urutan").Order("id")
+	if ctx.QueryParam("include_inactive") != "true" {
+		query = query.Where("aktif = ?", true)
+	}
+	if search != "" {
+		query = query.Where("jenis_dokumen LIKE ?", "%"+search+"%")
+	}
+	if err := query.Find(&jenisdokumen).Error; err != nil { // SELECT * FROM users
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Jenis Dokumen"})
+	}
+	if err := i18n.Apply(h.db, "jenis_dokumen", i18n.Locales(ctx), jenisdokumen, func(x *JenisDokumen) (int64, *string) { return x.ID, &x.Jenis_Dokumen }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Jenis Dokumen"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Jenis Dokumen", "data": jenisdokumen, "filter": search})
+}
+
+func (h *JenisDokumenHandler) CreateJenisDokumen(ctx echo.Context) error {
+	var input JenisDokumenRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	jenisdokumen := &JenisDokumen{
+		Jenis_Dokumen: input.Jenis_Dokumen,
+		Kode:          input.Kode,
+		Urutan:        input.Urutan,
+		CreatedAt:     time.Now(),
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(jenisdokumen).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil && !*input.Aktif {
+			if err := tx.Model(jenisdokumen).Update("aktif", false).Error; err != nil {
+				return err
+			}
+			jenisdokumen.Aktif = false
+		}
+		return audit.Catat(tx, ctx, "jenis_dokumen", jenisdokumen.ID, nil, jenisdokumen)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Create Jenis Dokumen"})
+	}
+
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Succesfully Create a Jenis Dokumen", "data": jenisdokumen})
+}
+
+func (h *JenisDokumenHandler) GetJenisDokumenByID(ctx echo.Context) error {
+	var input JenisDokumenRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	jenisdokumen := new(JenisDokumen)
+
+	if err := h.db.Where("id =?", input.ID).First(&jenisdokumen).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Jenis Dokumen By ID"})
+	}
+	if err := i18n.Apply(h.db, "jenis_dokumen", i18n.Locales(ctx), []*JenisDokumen{jenisdokumen}, func(x *JenisDokumen) (int64, *string) { return x.ID, &x.Jenis_Dokumen }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Jenis Dokumen By ID"})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Jenis Dokumen By ID : %s", input.ID), "data": jenisdokumen})
+}
+
+func (h *JenisDokumenHandler) UpdateJenisDokumen(ctx echo.Context) error {
+	var input JenisDokumenRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	jenisDokumenID, _ := strconv.Atoi(input.ID)
+
+	jenisdokumen := JenisDokumen{
+		ID:            int64(jenisDokumenID),
+		Jenis_Dokumen: input.Jenis_Dokumen,
+		Kode:          input.Kode,
+		Urutan:        input.Urutan,
+		UpdatedAt:     time.Now(),
+	}
+
+	sebelum := new(JenisDokumen)
+	if err := h.db.First(sebelum, jenisDokumenID).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Jenis Dokumen not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&JenisDokumen{}).Where("id = ?", jenisDokumenID).Select("jenis_dokumen", "kode", "urutan", "updated_at")
+		if err := query.Updates(&jenisdokumen).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil {
+			if err := tx.Model(&JenisDokumen{}).Where("id = ?", jenisDokumenID).Update("aktif", *input.Aktif).Error; err != nil {
+				return err
+			}
+		}
+		sesudah := new(JenisDokumen)
+		if err := tx.First(sesudah, jenisDokumenID).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "jenis_dokumen", jenisDokumenID, sebelum, sesudah)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Update Jenis Dokumen By ID", "error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Update Jenis Dokumen By ID : %s", input.ID), "data": input})
+}
+
+func (h *JenisDokumenHandler) DeleteJenisDokumen(ctx echo.Context) error {
+	var input JenisDokumenRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	sebelum := new(JenisDokumen)
+	if err := h.db.Where("id = ?", input.ID).First(sebelum).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Jenis Dokumen not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(sebelum).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "jenis_dokumen", sebelum.ID, sebelum, nil)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Delete Jenis Dokumen By ID"})
+	}
+	return ctx.JSON(http.StatusNoContent, nil)
+}
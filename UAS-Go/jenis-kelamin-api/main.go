@@ -8,7 +8,14 @@ import (
 	"strconv"
 	"time"
 
+	"uas/audit"
+	"uas/auth"
+	"uas/i18n"
+	"uas/keamanan"
+	"uas/ratelimit"
+
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -32,10 +39,10 @@ func initDB() (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = db.AutoMigrate(&JenisKelamin{})
-    if err != nil {
-        return nil, err
-    }
+	err = db.AutoMigrate(&JenisKelamin{}, &i18n.Terjemahan{}, &auth.TokenDicabut{}, &audit.Log{})
+	if err != nil {
+		return nil, err
+	}
 
 	return db, nil
 }
@@ -46,24 +53,47 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	limiter, err := ratelimit.FromEnv(db)
+	if err != nil {
+		panic(err)
+	}
+	keamananConfig, err := keamanan.FromEnv()
+	if err != nil {
+		panic(err)
+	}
 	// inisialisasi handler
 	jenisKelaminHandler := NewJenisKelaminHandler(db)
+	terjemahanHandler := i18n.NewTerjemahanHandler(db, "jenis_kelamin")
 
 	e := echo.New()
+	e.IPExtractor = limiter.IPExtractor()
+	e.Use(middleware.RequestID())
+	e.Use(keamanan.Middleware(keamananConfig))
+	e.Use(limiter.PerIP(ratelimit.GroupIP))
+	e.Use(auth.Middleware(db))
+	e.Use(limiter.PerClient(ratelimit.GroupDefault))
+	e.Use(auth.RequireLookup(db))
 	// routing
 	e.GET("/jeniskelamin", jenisKelaminHandler.GetAllJenisKelamin)
 	e.GET("/jeniskelamin/:id", jenisKelaminHandler.GetJenisKelaminByID)
 	e.POST("/jeniskelamin", jenisKelaminHandler.CreateJenisKelamin)
 	e.PUT("/jeniskelamin/:id", jenisKelaminHandler.UpdateJenisKelamin)
 	e.DELETE("/jeniskelamin/:id", jenisKelaminHandler.DeleteJenisKelamin)
+	e.GET("/jeniskelamin/:id/terjemahan", terjemahanHandler.GetAllTerjemahan)
+	e.PUT("/jeniskelamin/:id/terjemahan/:locale", terjemahanHandler.SetTerjemahan)
+	e.DELETE("/jeniskelamin/:id/terjemahan/:locale", terjemahanHandler.DeleteTerjemahan)
 	e.Logger.Fatal(e.Start(":1882"))
 }
 
+// JenisKelamin is a sex an employee can be recorded with.
 type JenisKelamin struct {
-	ID     				int64  `json:"id"`
-	Jenis_Kelamin	  	string `json:"jenis_kelamin"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID            int64     `json:"id"`
+	Jenis_Kelamin string    `json:"jenis_kelamin"`
+	Kode          string    `gorm:"size:20" json:"kode"`
+	Urutan        int       `json:"urutan"`
+	Aktif         bool      `gorm:"default:true" json:"aktif"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 func (JenisKelamin) TableName() string {
@@ -79,20 +109,29 @@ func NewJenisKelaminHandler(db *gorm.DB) *JenisKelaminHandler {
 }
 
 type JenisKelaminRequest struct {
-	ID     			 string `param:"id"`
-	Jenis_Kelamin    string `json:"jenis_kelamin"`
+	ID            string `param:"id"`
+	Jenis_Kelamin string `json:"jenis_kelamin"`
+	Kode          string `json:"kode"`
+	Urutan        int    `json:"urutan"`
+	Aktif         *bool  `json:"aktif"`
 }
 
 func (h *JenisKelaminHandler) GetAllJenisKelamin(ctx echo.Context) error {
 	search := ctx.QueryParam("search")
 	jeniskelamin := make([]*JenisKelamin, 0)
-	query := h.db.Model(&JenisKelamin{})
+	query := h.db.Model(&JenisKelamin{}).Order("urutan").Order("id")
+	if ctx.QueryParam("include_inactive") != "true" {
+		query = query.Where("aktif = ?", true)
+	}
 	if search != "" {
 		query = query.Where("jeniskelamin LIKE ?", "%"+search+"%")
 	}
 	if err := query.Find(&jeniskelamin).Error; err != nil { // SELECT * FROM users
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Jenis Kelamin"})
 	}
+	if err := i18n.Apply(h.db, "jenis_kelamin", i18n.Locales(ctx), jeniskelamin, func(x *JenisKelamin) (int64, *string) { return x.ID, &x.Jenis_Kelamin }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Jenis Kelamin"})
+	}
 	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Jenis Kelamin", "data": jeniskelamin, "filter": search})
 }
 
@@ -103,11 +142,25 @@ func (h *JenisKelaminHandler) CreateJenisKelamin(ctx echo.Context) error {
 	}
 
 	jeniskelamin := &JenisKelamin{
-		Jenis_Kelamin:    input.Jenis_Kelamin,
-		CreatedAt: time.Now(),
+		Jenis_Kelamin: input.Jenis_Kelamin,
+		Kode:          input.Kode,
+		Urutan:        input.Urutan,
+		CreatedAt:     time.Now(),
 	}
 
-	if err := h.db.Create(jeniskelamin).Error; err != nil { // INSERT INTO users (nim, nama, alamat) VALUES('')
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(jeniskelamin).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil && !*input.Aktif {
+			if err := tx.Model(jeniskelamin).Update("aktif", false).Error; err != nil {
+				return err
+			}
+			jeniskelamin.Aktif = false
+		}
+		return audit.Catat(tx, ctx, "jenis_kelamin", jeniskelamin.ID, nil, jeniskelamin)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Create Jenis Kelamin"})
 	}
 
@@ -125,6 +178,9 @@ func (h *JenisKelaminHandler) GetJenisKelaminByID(ctx echo.Context) error {
 	if err := h.db.Where("id =?", input.ID).First(&jeniskelamin).Error; err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Jenis Kelamin By ID"})
 	}
+	if err := i18n.Apply(h.db, "jenis_kelamin", i18n.Locales(ctx), []*JenisKelamin{jeniskelamin}, func(x *JenisKelamin) (int64, *string) { return x.ID, &x.Jenis_Kelamin }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Jenis Kelamin By ID"})
+	}
 
 	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Jenis Kelamin By ID : %s", input.ID), "data": jeniskelamin})
 }
@@ -138,13 +194,34 @@ func (h *JenisKelaminHandler) UpdateJenisKelamin(ctx echo.Context) error {
 	jeniskelaminID, _ := strconv.Atoi(input.ID)
 
 	jeniskelamin := JenisKelamin{
-		ID:     int64(jeniskelaminID),
-		Jenis_Kelamin:    input.Jenis_Kelamin,
-		UpdatedAt: time.Now(),
+		ID:            int64(jeniskelaminID),
+		Jenis_Kelamin: input.Jenis_Kelamin,
+		Kode:          input.Kode,
+		Urutan:        input.Urutan,
+		UpdatedAt:     time.Now(),
 	}
 
-	query := h.db.Model(&JenisKelamin{}).Where("id = ?", jeniskelaminID)
-	if err := query.Updates(&jeniskelamin).Error; err != nil {
+	sebelum := new(JenisKelamin)
+	if err := h.db.First(sebelum, jeniskelaminID).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Jenis Kelamin not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&JenisKelamin{}).Where("id = ?", jeniskelaminID).Select("jenis_kelamin", "kode", "urutan", "updated_at")
+		if err := query.Updates(&jeniskelamin).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil {
+			if err := tx.Model(&JenisKelamin{}).Where("id = ?", jeniskelaminID).Update("aktif", *input.Aktif).Error; err != nil {
+				return err
+			}
+		}
+		sesudah := new(JenisKelamin)
+		if err := tx.First(sesudah, jeniskelaminID).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "jenis_kelamin", jeniskelaminID, sebelum, sesudah)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Update Jenis Kelamin By ID", "error": err.Error()})
 	}
 
@@ -157,8 +234,18 @@ func (h *JenisKelaminHandler) DeleteJenisKelamin(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
 	}
 
-	if err := h.db.Delete(&JenisKelamin{}, input.ID).Error; err != nil {
+	sebelum := new(JenisKelamin)
+	if err := h.db.Where("id = ?", input.ID).First(sebelum).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Jenis Kelamin not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(sebelum).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "jenis_kelamin", sebelum.ID, sebelum, nil)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Delete Jenis Kelamin By ID"})
 	}
 	return ctx.JSON(http.StatusNoContent, nil)
-}
\ No newline at end of file
+}
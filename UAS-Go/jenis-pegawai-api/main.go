@@ -8,7 +8,14 @@ import (
 	"strconv"
 	"time"
 
+	"uas/audit"
+	"uas/auth"
+	"uas/i18n"
+	"uas/keamanan"
+	"uas/ratelimit"
+
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -32,10 +39,10 @@ func initDB() (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = db.AutoMigrate(&JenisPegawai{})
-    if err != nil {
-        return nil, err
-    }
+	err = db.AutoMigrate(&JenisPegawai{}, &i18n.Terjemahan{}, &auth.TokenDicabut{}, &audit.Log{})
+	if err != nil {
+		return nil, err
+	}
 
 	return db, nil
 }
@@ -46,24 +53,47 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	limiter, err := ratelimit.FromEnv(db)
+	if err != nil {
+		panic(err)
+	}
+	keamananConfig, err := keamanan.FromEnv()
+	if err != nil {
+		panic(err)
+	}
 	// inisialisasi handler
 	jenisPegawaiHandler := NewJenisPegawaiHandler(db)
+	terjemahanHandler := i18n.NewTerjemahanHandler(db, "jenis_pegawai")
 
 	e := echo.New()
+	e.IPExtractor = limiter.IPExtractor()
+	e.Use(middleware.RequestID())
+	e.Use(keamanan.Middleware(keamananConfig))
+	e.Use(limiter.PerIP(ratelimit.GroupIP))
+	e.Use(auth.Middleware(db))
+	e.Use(limiter.PerClient(ratelimit.GroupDefault))
+	e.Use(auth.RequireLookup(db))
 	// routing
 	e.GET("/jenispegawai", jenisPegawaiHandler.GetAllJenisPegawai)
 	e.GET("/jenispegawai/:id", jenisPegawaiHandler.GetJenisPegawaiByID)
 	e.POST("/jenispegawai", jenisPegawaiHandler.CreateJenisPegawai)
 	e.PUT("/jenispegawai/:id", jenisPegawaiHandler.UpdateJenisPegawai)
 	e.DELETE("/jenispegawai/:id", jenisPegawaiHandler.DeleteJenisPegawai)
+	e.GET("/jenispegawai/:id/terjemahan", terjemahanHandler.GetAllTerjemahan)
+	e.PUT("/jenispegawai/:id/terjemahan/:locale", terjemahanHandler.SetTerjemahan)
+	e.DELETE("/jenispegawai/:id/terjemahan/:locale", terjemahanHandler.DeleteTerjemahan)
 	e.Logger.Fatal(e.Start(":1882"))
 }
 
+// JenisPegawai is a kind of employment, e.g. dosen or tenaga kependidikan.
 type JenisPegawai struct {
-	ID     				int64  `json:"id"`
-	Jenis_Pegawai   	string `json:"jenis_pegawai"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID            int64     `json:"id"`
+	Jenis_Pegawai string    `json:"jenis_pegawai"`
+	Kode          string    `gorm:"size:20" json:"kode"`
+	Urutan        int       `json:"urutan"`
+	Aktif         bool      `gorm:"default:true" json:"aktif"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 func (JenisPegawai) TableName() string {
@@ -79,20 +109,29 @@ func NewJenisPegawaiHandler(db *gorm.DB) *JenisPegawaiHandler {
 }
 
 type JenisPegawaiRequest struct {
-	ID     			 string `param:"id"`
-	Jenis_Pegawai    string `json:"jenis_pegawai"`
+	ID            string `param:"id"`
+	Jenis_Pegawai string `json:"jenis_pegawai"`
+	Kode          string `json:"kode"`
+	Urutan        int    `json:"urutan"`
+	Aktif         *bool  `json:"aktif"`
 }
 
 func (h *JenisPegawaiHandler) GetAllJenisPegawai(ctx echo.Context) error {
 	search := ctx.QueryParam("search")
 	jenispegawai := make([]*JenisPegawai, 0)
-	query := h.db.Model(&JenisPegawai{})
+	query := h.db.Model(&JenisPegawai{}).Order("urutan").Order("id")
+	if ctx.QueryParam("include_inactive") != "true" {
+		query = query.Where("aktif = ?", true)
+	}
 	if search != "" {
 		query = query.Where("jenispegawai LIKE ?", "%"+search+"%")
 	}
 	if err := query.Find(&jenispegawai).Error; err != nil { // SELECT * FROM users
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Jenis Pegawai"})
 	}
+	if err := i18n.Apply(h.db, "jenis_pegawai", i18n.Locales(ctx), jenispegawai, func(x *JenisPegawai) (int64, *string) { return x.ID, &x.Jenis_Pegawai }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Jenis Pegawai"})
+	}
 	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Users", "data": jenispegawai, "filter": search})
 }
 
@@ -103,11 +142,25 @@ func (h *JenisPegawaiHandler) CreateJenisPegawai(ctx echo.Context) error {
 	}
 
 	jenispegawai := &JenisPegawai{
-		Jenis_Pegawai:    input.Jenis_Pegawai,
-		CreatedAt: time.Now(),
+		Jenis_Pegawai: input.Jenis_Pegawai,
+		Kode:          input.Kode,
+		Urutan:        input.Urutan,
+		CreatedAt:     time.Now(),
 	}
 
-	if err := h.db.Create(jenispegawai).Error; err != nil { // INSERT INTO users (nim, nama, alamat) VALUES('')
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(jenispegawai).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil && !*input.Aktif {
+			if err := tx.Model(jenispegawai).Update("aktif", false).Error; err != nil {
+				return err
+			}
+			jenispegawai.Aktif = false
+		}
+		return audit.Catat(tx, ctx, "jenis_pegawai", jenispegawai.ID, nil, jenispegawai)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Create Jenis Pegawai"})
 	}
 
@@ -125,6 +178,9 @@ func (h *JenisPegawaiHandler) GetJenisPegawaiByID(ctx echo.Context) error {
 	if err := h.db.Where("id =?", input.ID).First(&jenispegawai).Error; err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Jenis Pegawai By ID"})
 	}
+	if err := i18n.Apply(h.db, "jenis_pegawai", i18n.Locales(ctx), []*JenisPegawai{jenispegawai}, func(x *JenisPegawai) (int64, *string) { return x.ID, &x.Jenis_Pegawai }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Jenis Pegawai By ID"})
+	}
 
 	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Jenis Pegawai By ID : %s", input.ID), "data": jenispegawai})
 }
@@ -138,13 +194,34 @@ func (h *JenisPegawaiHandler) UpdateJenisPegawai(ctx echo.Context) error {
 	jenispegawaiID, _ := strconv.Atoi(input.ID)
 
 	jenispegawai := JenisPegawai{
-		ID:     int64(jenispegawaiID),
-		Jenis_Pegawai:    input.Jenis_Pegawai,
-		UpdatedAt: time.Now(),
+		ID:            int64(jenispegawaiID),
+		Jenis_Pegawai: input.Jenis_Pegawai,
+		Kode:          input.Kode,
+		Urutan:        input.Urutan,
+		UpdatedAt:     time.Now(),
 	}
 
-	query := h.db.Model(&JenisPegawai{}).Where("id = ?", jenispegawaiID)
-	if err := query.Updates(&jenispegawai).Error; err != nil {
+	sebelum := new(JenisPegawai)
+	if err := h.db.First(sebelum, jenispegawaiID).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Jenis Pegawai not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&JenisPegawai{}).Where("id = ?", jenispegawaiID).Select("jenis_pegawai", "kode", "urutan", "updated_at")
+		if err := query.Updates(&jenispegawai).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil {
+			if err := tx.Model(&JenisPegawai{}).Where("id = ?", jenispegawaiID).Update("aktif", *input.Aktif).Error; err != nil {
+				return err
+			}
+		}
+		sesudah := new(JenisPegawai)
+		if err := tx.First(sesudah, jenispegawaiID).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "jenis_pegawai", jenispegawaiID, sebelum, sesudah)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Update Jenis Pegawai By ID", "error": err.Error()})
 	}
 
@@ -157,8 +234,18 @@ func (h *JenisPegawaiHandler) DeleteJenisPegawai(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
 	}
 
-	if err := h.db.Delete(&JenisPegawai{}, input.ID).Error; err != nil {
+	sebelum := new(JenisPegawai)
+	if err := h.db.Where("id = ?", input.ID).First(sebelum).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Jenis Pegawai not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(sebelum).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "jenis_pegawai", sebelum.ID, sebelum, nil)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Delete Jenis Pegawai By ID"})
 	}
 	return ctx.JSON(http.StatusNoContent, nil)
-}
\ No newline at end of file
+}
@@ -0,0 +1,162 @@
+// Package keamanan sets the headers browsers need to call the services from
+// the front-end on another origin (CORS) and the usual security headers.
+// Every service registers Middleware first, before authentication, so CORS
+// preflight requests are answered without a token.
+package keamanan
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"uas/auth"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	// defaultCSP fits the JSON and files the services return: nothing in
+	// them may load anything or be framed.
+	defaultCSP = "default-src 'none'; frame-ancestors 'none'"
+	// defaultDocsCSP lets an API documentation UI under /docs load its own
+	// scripts, styles and images.
+	defaultDocsCSP = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'"
+	// docsPrefix is where a service serves its API documentation UI.
+	docsPrefix = "/docs"
+)
+
+// Config configures Middleware.
+type Config struct {
+	AllowOrigins     []string // origins allowed to call the services; none disables CORS
+	AllowMethods     []string
+	AllowHeaders     []string
+	AllowCredentials bool // allow cookies and HTTP authentication, needs explicit origins
+	MaxAge           int  // seconds browsers may cache a preflight response
+	HSTSMaxAge       int  // seconds of Strict-Transport-Security on HTTPS requests, 0 turns it off
+	CSP              string
+	DocsCSP          string // CSP of responses under /docs
+}
+
+// exposeHeaders are response headers the front-end may read.
+var exposeHeaders = []string{
+	echo.HeaderXRequestID,
+	echo.HeaderLocation,
+	echo.HeaderRetryAfter,
+	echo.HeaderContentDisposition,
+	"ETag",
+	"Upload-Offset",
+	"RateLimit-Policy",
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+}
+
+// FromEnv reads the Config from the environment:
+//
+//	CORS_ALLOW_ORIGINS       comma separated origins, e.g.
+//	                         "https://hr.example.ac.id"; "*" allows any
+//	                         origin, empty (default) disables CORS
+//	CORS_ALLOW_METHODS       default "GET,HEAD,POST,PUT,PATCH,DELETE"
+//	CORS_ALLOW_HEADERS       default "Authorization,Content-Type,
+//	                         Accept-Language,X-API-Key,X-Request-Id,Upload-Offset"
+//	CORS_ALLOW_CREDENTIALS   true to allow credentials (default false)
+//	CORS_MAX_AGE             preflight cache in seconds (default 600)
+//	SECURITY_HSTS_MAX_AGE    HSTS max-age in seconds (default 31536000)
+//	SECURITY_CSP             Content-Security-Policy of API responses
+//	SECURITY_CSP_DOCS        Content-Security-Policy of the docs UI
+func FromEnv() (Config, error) {
+	cfg := Config{
+		AllowOrigins: list(os.Getenv("CORS_ALLOW_ORIGINS"), nil),
+		AllowMethods: list(os.Getenv("CORS_ALLOW_METHODS"), []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}),
+		AllowHeaders: list(os.Getenv("CORS_ALLOW_HEADERS"), []string{echo.HeaderAuthorization, echo.HeaderContentType, "Accept-Language", auth.HeaderAPIKey, echo.HeaderXRequestID, "Upload-Offset"}),
+		MaxAge:       600,
+		HSTSMaxAge:   31536000,
+		CSP:          getEnv("SECURITY_CSP", defaultCSP),
+		DocsCSP:      getEnv("SECURITY_CSP_DOCS", defaultDocsCSP),
+	}
+	var err error
+	if value := os.Getenv("CORS_ALLOW_CREDENTIALS"); value != "" {
+		if cfg.AllowCredentials, err = strconv.ParseBool(value); err != nil {
+			return cfg, errors.New("keamanan: invalid CORS_ALLOW_CREDENTIALS")
+		}
+	}
+	if value := os.Getenv("CORS_MAX_AGE"); value != "" {
+		if cfg.MaxAge, err = strconv.Atoi(value); err != nil || cfg.MaxAge < 0 {
+			return cfg, errors.New("keamanan: invalid CORS_MAX_AGE")
+		}
+	}
+	if value := os.Getenv("SECURITY_HSTS_MAX_AGE"); value != "" {
+		if cfg.HSTSMaxAge, err = strconv.Atoi(value); err != nil || cfg.HSTSMaxAge < 0 {
+			return cfg, errors.New("keamanan: invalid SECURITY_HSTS_MAX_AGE")
+		}
+	}
+	// Browsers refuse credentials for a wildcard origin, and reflecting
+	// every origin instead would let any site act as the logged in user.
+	if cfg.AllowCredentials {
+		for _, origin := range cfg.AllowOrigins {
+			if origin == "*" {
+				return cfg, errors.New("keamanan: CORS_ALLOW_CREDENTIALS needs explicit CORS_ALLOW_ORIGINS")
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// Middleware answers CORS requests and sets the security headers. Responses
+// under /docs get DocsCSP instead of CSP.
+func Middleware(cfg Config) echo.MiddlewareFunc {
+	secure := func(csp string) echo.MiddlewareFunc {
+		return middleware.SecureWithConfig(middleware.SecureConfig{
+			XSSProtection:         "0",
+			ContentTypeNosniff:    "nosniff",
+			XFrameOptions:         "DENY",
+			HSTSMaxAge:            cfg.HSTSMaxAge,
+			ContentSecurityPolicy: csp,
+			ReferrerPolicy:        "no-referrer",
+		})
+	}
+	api, docs := secure(cfg.CSP), secure(cfg.DocsCSP)
+	cors := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	if len(cfg.AllowOrigins) > 0 {
+		cors = middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:     cfg.AllowOrigins,
+			AllowMethods:     cfg.AllowMethods,
+			AllowHeaders:     cfg.AllowHeaders,
+			AllowCredentials: cfg.AllowCredentials,
+			ExposeHeaders:    exposeHeaders,
+			MaxAge:           cfg.MaxAge,
+		})
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		api, docs := cors(api(next)), cors(docs(next))
+		return func(ctx echo.Context) error {
+			if path := ctx.Request().URL.Path; path == docsPrefix || strings.HasPrefix(path, docsPrefix+"/") {
+				return docs(ctx)
+			}
+			return api(ctx)
+		}
+	}
+}
+
+func list(value string, fallback []string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return fallback
+	}
+	return items
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
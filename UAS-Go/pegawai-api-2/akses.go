@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+
+	"uas/auth"
+	"uas/privasi"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// cekUnit guards routes with an :id parameter naming a Pegawai: callers whose
+// izin is limited to some units may only reach employees of those units.
+// Unknown IDs are passed on so the handler can answer 404.
+func cekUnit(db *gorm.DB, izin string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			akses, err := auth.AksesFrom(db, ctx)
+			if err != nil {
+				return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to load permissions"})
+			}
+			if akses.Global(izin) {
+				return next(ctx)
+			}
+			var units []string
+			if err := db.Model(&Pegawai{}).Unscoped().Where("id = ?", ctx.Param("id")).Pluck("unit", &units).Error; err != nil {
+				return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Pegawai"})
+			}
+			if len(units) > 0 && !akses.BolehUnit(izin, units[0]) {
+				return auth.Forbidden(ctx)
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// scopeUnit limits query to the employees the caller may access with izin.
+func scopeUnit(db *gorm.DB, ctx echo.Context, izin string, query *gorm.DB) (*gorm.DB, error) {
+	akses, err := auth.AksesFrom(db, ctx)
+	if err != nil {
+		return nil, err
+	}
+	// An empty list renders as IN (NULL) and matches nothing
+	if units, semua := akses.Scope(izin); !semua {
+		query = query.Where("unit IN ?", units)
+	}
+	return query, nil
+}
+
+// bolehUnit reports whether the caller has izin for employees of unit.
+func bolehUnit(db *gorm.DB, ctx echo.Context, izin, unit string) (bool, error) {
+	akses, err := auth.AksesFrom(db, ctx)
+	if err != nil {
+		return false, err
+	}
+	return akses.BolehUnit(izin, unit), nil
+}
+
+// unitError writes the response for a failed bolehUnit check.
+func unitError(ctx echo.Context, err error) error {
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to load permissions"})
+	}
+	return auth.Forbidden(ctx)
+}
+
+// tampil writes body as JSON, with the sensitive personal data the caller
+// may not see masked or left out, see package privasi.
+func tampil(db *gorm.DB, ctx echo.Context, code int, body interface{}) error {
+	akses, err := auth.AksesFrom(db, ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to load permissions"})
+	}
+	body, err = privasi.Untuk(akses).Terapkan(body)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to encode response"})
+	}
+	return ctx.JSON(code, body)
+}
@@ -0,0 +1,12 @@
+package main
+
+import "os"
+
+// getEnv returns the value of the environment variable key, or fallback
+// when it is unset or empty.
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"uas/auth"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusCutiDiajukan  = "diajukan"
+	StatusCutiDisetujui = "disetujui"
+	StatusCutiDitolak   = "ditolak"
+)
+
+// Cuti is a leave request. Jumlah_Hari counts working days only: weekends and
+// HariLibur dates inside the range are excluded.
+type Cuti struct {
+	ID            int64     `json:"id"`
+	Pegawai_ID    int64     `gorm:"index" json:"pegawai_id"`
+	Jenis_Cuti_ID int64     `json:"jenis_cuti_id"`
+	Tgl_Mulai     string    `gorm:"size:10" json:"tgl_mulai"`
+	Tgl_Selesai   string    `gorm:"size:10" json:"tgl_selesai"`
+	Jumlah_Hari   int       `json:"jumlah_hari"`
+	Alasan        string    `json:"alasan"`
+	Status        string    `gorm:"size:20;index" json:"status"`
+	Diputus_Oleh  *int64    `json:"diputus_oleh"` // unit head who approved or rejected the request
+	Catatan       string    `json:"catatan"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (Cuti) TableName() string {
+	return "cuti"
+}
+
+// SaldoCuti is the leave balance of one employee for one leave type and year.
+// Rows are opened lazily from HakCuti the first time they are needed.
+type SaldoCuti struct {
+	ID            int64     `json:"id"`
+	Pegawai_ID    int64     `gorm:"uniqueIndex:idx_saldo_cuti" json:"pegawai_id"`
+	Jenis_Cuti_ID int64     `gorm:"uniqueIndex:idx_saldo_cuti" json:"jenis_cuti_id"`
+	Tahun         int       `gorm:"uniqueIndex:idx_saldo_cuti" json:"tahun"`
+	Hak           int       `json:"hak"`
+	Carry_Over    int       `json:"carry_over"`
+	Terpakai      int       `json:"terpakai"`
+	Ditutup       bool      `json:"ditutup"` // closed when employment ends, nothing is left
+	Sisa          int       `gorm:"-" json:"sisa"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (SaldoCuti) TableName() string {
+	return "saldo_cuti"
+}
+
+func (s *SaldoCuti) AfterFind(tx *gorm.DB) error {
+	s.Sisa = s.Hak + s.Carry_Over - s.Terpakai
+	if s.Ditutup {
+		s.Sisa = 0
+	}
+	return nil
+}
+
+type CutiHandler struct {
+	db *gorm.DB
+}
+
+func NewCutiHandler(db *gorm.DB) *CutiHandler {
+	return &CutiHandler{db: db}
+}
+
+type CutiRequest struct {
+	Pegawai_ID    string `param:"id"`
+	Jenis_Cuti_ID int64  `json:"jenis_cuti_id"`
+	Tgl_Mulai     string `json:"tgl_mulai"`
+	Tgl_Selesai   string `json:"tgl_selesai"`
+	Alasan        string `json:"alasan"`
+}
+
+type KeputusanCutiRequest struct {
+	ID      string `param:"cuti_id"`
+	Catatan string `json:"catatan"`
+}
+
+type CarryOverRequest struct {
+	Tahun int `json:"tahun"`
+}
+
+// hitungHariCuti counts the working days between start and end inclusive.
+func hitungHariCuti(db *gorm.DB, start, end time.Time) (int, error) {
+	days, err := hariKerja(db, start, end)
+	return len(days), err
+}
+
+// hariKerja lists the working days between start and end inclusive, skipping
+// weekends and HariLibur dates.
+func hariKerja(db *gorm.DB, start, end time.Time) ([]time.Time, error) {
+	var libur []string
+	err := db.Model(&HariLibur{}).
+		Where("tanggal BETWEEN ? AND ?", start.Format(tglLayout), end.Format(tglLayout)).
+		Pluck("tanggal", &libur).Error
+	if err != nil {
+		return nil, err
+	}
+	isLibur := make(map[string]bool, len(libur))
+	for _, tanggal := range libur {
+		isLibur[tanggal] = true
+	}
+
+	var days []time.Time
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() == time.Saturday || d.Weekday() == time.Sunday || isLibur[d.Format(tglLayout)] {
+			continue
+		}
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+// saldoBaru returns the balance a new row for the given employee, leave type
+// and year starts with, taken from the employee's HakCuti.
+func saldoBaru(db *gorm.DB, pegawai *Pegawai, jenisCutiID int64, tahun int) (*SaldoCuti, error) {
+	var hak HakCuti
+	err := db.Where("jenis_pegawai_id = ? AND jenis_cuti_id = ?", pegawai.Jenis_Pegawai_ID, jenisCutiID).First(&hak).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &SaldoCuti{Pegawai_ID: pegawai.ID, Jenis_Cuti_ID: jenisCutiID, Tahun: tahun, Hak: hak.Jumlah_Hari, Sisa: hak.Jumlah_Hari}, nil
+}
+
+// lihatSaldo returns the balance for the given employee, leave type and year
+// without opening it: a balance that doesn't exist yet is returned unsaved.
+func lihatSaldo(db *gorm.DB, pegawai *Pegawai, jenisCutiID int64, tahun int) (*SaldoCuti, error) {
+	saldo := new(SaldoCuti)
+	err := db.Where("pegawai_id = ? AND jenis_cuti_id = ? AND tahun = ?", pegawai.ID, jenisCutiID, tahun).First(saldo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return saldoBaru(db, pegawai, jenisCutiID, tahun)
+	}
+	return saldo, err
+}
+
+// openSaldo returns the balance row for the given employee, leave type and
+// year, creating it from the employee's HakCuti if needed. The row is locked
+// for update, so callers changing it must run inside a transaction.
+func openSaldo(tx *gorm.DB, pegawai *Pegawai, jenisCutiID int64, tahun int) (*SaldoCuti, error) {
+	saldo, err := saldoBaru(tx, pegawai, jenisCutiID, tahun)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(saldo).Error; err != nil {
+		return nil, err
+	}
+	saldo = new(SaldoCuti)
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("pegawai_id = ? AND jenis_cuti_id = ? AND tahun = ?", pegawai.ID, jenisCutiID, tahun).
+		First(saldo).Error
+	return saldo, err
+}
+
+func (h *CutiHandler) GetAllCuti(ctx echo.Context) error {
+	var input CutiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	if _, err := findPegawai(h.db, input.Pegawai_ID); err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	cuti := make([]*Cuti, 0)
+	query := h.db.Where("pegawai_id = ?", input.Pegawai_ID).Order("tgl_mulai")
+	if tahun := ctx.QueryParam("tahun"); tahun != "" {
+		query = query.Where("tgl_mulai LIKE ?", tahun+"-%")
+	}
+	if status := ctx.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Find(&cuti).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Cuti"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Cuti", "data": cuti})
+}
+
+func (h *CutiHandler) CreateCuti(ctx echo.Context) error {
+	var input CutiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := findPegawai(h.db, input.Pegawai_ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	start, err := time.Parse(tglLayout, input.Tgl_Mulai)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "tgl_mulai must be formatted as YYYY-MM-DD"})
+	}
+	end, err := time.Parse(tglLayout, input.Tgl_Selesai)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "tgl_selesai must be formatted as YYYY-MM-DD"})
+	}
+	if end.Before(start) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "tgl_selesai must not be before tgl_mulai"})
+	}
+	if end.Year() != start.Year() {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Cuti must not span multiple years"})
+	}
+
+	jenisCuti := new(JenisCuti)
+	if err := h.db.First(jenisCuti, input.Jenis_Cuti_ID).Error; err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("Jenis Cuti %d not found", input.Jenis_Cuti_ID)})
+	}
+
+	days, err := hitungHariCuti(h.db, start, end)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Cuti"})
+	}
+	if days == 0 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Cuti contains no working days"})
+	}
+
+	cuti := Cuti{
+		Pegawai_ID:    pegawai.ID,
+		Jenis_Cuti_ID: jenisCuti.ID,
+		Tgl_Mulai:     input.Tgl_Mulai,
+		Tgl_Selesai:   input.Tgl_Selesai,
+		Jumlah_Hari:   days,
+		Alasan:        input.Alasan,
+		Status:        StatusCutiDiajukan,
+	}
+
+	var insufficient, overlaps bool
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the employee serialises their requests, so two overlapping
+		// ones can't both pass the check below.
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(new(Pegawai), pegawai.ID).Error; err != nil {
+			return err
+		}
+		var overlap int64
+		err := tx.Model(&Cuti{}).
+			Where("pegawai_id = ? AND status <> ?", pegawai.ID, StatusCutiDitolak).
+			Where("tgl_mulai <= ? AND tgl_selesai >= ?", input.Tgl_Selesai, input.Tgl_Mulai).
+			Count(&overlap).Error
+		if err != nil {
+			return err
+		}
+		if overlap > 0 {
+			overlaps = true
+			return nil
+		}
+
+		if jenisCuti.Potong_Saldo {
+			saldo, err := openSaldo(tx, pegawai, jenisCuti.ID, start.Year())
+			if err != nil {
+				return err
+			}
+			// Pending requests already claim part of the balance.
+			var pending int64
+			err = tx.Model(&Cuti{}).
+				Where("pegawai_id = ? AND jenis_cuti_id = ? AND status = ?", pegawai.ID, jenisCuti.ID, StatusCutiDiajukan).
+				Where("tgl_mulai LIKE ?", fmt.Sprintf("%d-%%", start.Year())).
+				Select("COALESCE(SUM(jumlah_hari), 0)").Scan(&pending).Error
+			if err != nil {
+				return err
+			}
+			if days > saldo.Sisa-int(pending) {
+				insufficient = true
+				return nil
+			}
+		}
+		return tx.Create(&cuti).Error
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Cuti"})
+	}
+	if overlaps {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": "Cuti overlaps an existing request"})
+	}
+	if insufficient {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Insufficient leave balance"})
+	}
+
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Cuti created successfully", "data": cuti})
+}
+
+func (h *CutiHandler) ApproveCuti(ctx echo.Context) error {
+	return h.putuskanCuti(ctx, StatusCutiDisetujui)
+}
+
+func (h *CutiHandler) RejectCuti(ctx echo.Context) error {
+	return h.putuskanCuti(ctx, StatusCutiDitolak)
+}
+
+// putuskanCuti approves or rejects a pending request. Only the head of the
+// requesting employee's unit may decide, as the employee linked to the
+// logged in user; approved days are deducted from the balance when the leave
+// type requires it.
+func (h *CutiHandler) putuskanCuti(ctx echo.Context, status string) error {
+	var input KeputusanCutiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	cuti := new(Cuti)
+	if err := h.db.Where("id = ?", input.ID).First(cuti).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Cuti not found"})
+	}
+	pegawai, err := findPegawai(h.db, strconv.FormatInt(cuti.Pegawai_ID, 10))
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	if ok, err := bolehUnit(h.db, ctx, auth.PegawaiWrite, pegawai.Unit); !ok {
+		return unitError(ctx, err)
+	}
+
+	approver, err := pegawaiSaya(h.db, ctx)
+	if err != nil && !errors.Is(err, errBukanUser) && !errors.Is(err, errTanpaPegawai) {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Cuti"})
+	}
+	kepala := new(KepalaUnit)
+	if err == nil {
+		err = h.db.Where("unit = ?", pegawai.Unit).First(kepala).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Cuti"})
+		}
+	}
+	if err != nil || kepala.Pegawai_ID != approver.ID {
+		return ctx.JSON(http.StatusForbidden, map[string]string{"message": "Only the head of unit " + pegawai.Unit + " may decide this Cuti"})
+	}
+	if cuti.Pegawai_ID == approver.ID {
+		return ctx.JSON(http.StatusForbidden, map[string]string{"message": "You can't decide your own Cuti"})
+	}
+
+	var conflict string
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(cuti, cuti.ID).Error; err != nil {
+			return err
+		}
+		if cuti.Status != StatusCutiDiajukan {
+			conflict = "Cuti has already been " + cuti.Status
+			return nil
+		}
+
+		if status == StatusCutiDisetujui {
+			jenisCuti := new(JenisCuti)
+			if err := tx.First(jenisCuti, cuti.Jenis_Cuti_ID).Error; err != nil {
+				return err
+			}
+			if jenisCuti.Potong_Saldo {
+				tgl, _ := time.Parse(tglLayout, cuti.Tgl_Mulai)
+				saldo, err := openSaldo(tx, pegawai, cuti.Jenis_Cuti_ID, tgl.Year())
+				if err != nil {
+					return err
+				}
+				if cuti.Jumlah_Hari > saldo.Sisa {
+					conflict = "Insufficient leave balance"
+					return nil
+				}
+				if err := tx.Model(saldo).Update("terpakai", saldo.Terpakai+cuti.Jumlah_Hari).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		cuti.Status = status
+		cuti.Diputus_Oleh = &approver.ID
+		cuti.Catatan = input.Catatan
+		return tx.Save(cuti).Error
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Cuti"})
+	}
+	if conflict != "" {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": conflict})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Cuti " + status, "data": cuti})
+}
+
+// GetSaldoCuti returns the balance of every leave type that is deducted from
+// a yearly balance. The year defaults to the current one; balances that
+// haven't been opened yet are shown as they would start.
+func (h *CutiHandler) GetSaldoCuti(ctx echo.Context) error {
+	pegawai, err := findPegawai(h.db, ctx.Param("id"))
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	tahun := time.Now().Year()
+	if param := ctx.QueryParam("tahun"); param != "" {
+		if tahun, err = strconv.Atoi(param); err != nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid tahun"})
+		}
+	}
+
+	var jenisCutiIDs []int64
+	if err := h.db.Model(&JenisCuti{}).Where("potong_saldo = ?", true).Pluck("id", &jenisCutiIDs).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Saldo Cuti"})
+	}
+	saldo := make([]*SaldoCuti, 0, len(jenisCutiIDs))
+	for _, jenisCutiID := range jenisCutiIDs {
+		s, err := lihatSaldo(h.db, pegawai, jenisCutiID, tahun)
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Saldo Cuti"})
+		}
+		saldo = append(saldo, s)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get Saldo Cuti", "data": saldo, "tahun": tahun})
+}
+
+// CarryOver moves unused days of the given year into the next year's balance,
+// capped by JenisCuti.Maks_Carry_Over. Running it again for the same year
+// recomputes the same values, so it is safe to retry.
+func (h *CutiHandler) CarryOver(ctx echo.Context) error {
+	var input CarryOverRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	if input.Tahun == 0 {
+		input.Tahun = time.Now().Year() - 1
+	}
+
+	carried := 0
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		jenisCuti := make([]*JenisCuti, 0)
+		if err := tx.Where("potong_saldo = ? AND maks_carry_over > 0", true).Find(&jenisCuti).Error; err != nil {
+			return err
+		}
+		for _, jc := range jenisCuti {
+			saldo := make([]*SaldoCuti, 0)
+			if err := tx.Where("jenis_cuti_id = ? AND tahun = ?", jc.ID, input.Tahun).Find(&saldo).Error; err != nil {
+				return err
+			}
+			for _, s := range saldo {
+				pegawai, err := findPegawai(tx, strconv.FormatInt(s.Pegawai_ID, 10))
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				carry := s.Sisa
+				if carry > jc.Maks_Carry_Over {
+					carry = jc.Maks_Carry_Over
+				}
+				if carry < 0 {
+					carry = 0
+				}
+				next, err := openSaldo(tx, pegawai, jc.ID, input.Tahun+1)
+				if err != nil {
+					return err
+				}
+				if err := tx.Model(next).Update("carry_over", carry).Error; err != nil {
+					return err
+				}
+				carried++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to carry over Saldo Cuti"})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully carried over Saldo Cuti %d to %d", input.Tahun, input.Tahun+1), "count": carried})
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"uas/audit"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JenisCuti is a leave type such as "Cuti Tahunan" or "Cuti Sakit".
+type JenisCuti struct {
+	ID              int64     `json:"id"`
+	Jenis_Cuti      string    `json:"jenis_cuti"`
+	Potong_Saldo    bool      `json:"potong_saldo"`    // approved requests are deducted from the yearly balance
+	Maks_Carry_Over int       `json:"maks_carry_over"` // unused days carried into next year, 0 disables carry-over
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+func (JenisCuti) TableName() string {
+	return "jenis_cuti"
+}
+
+// HakCuti is the yearly entitlement of a leave type for one jenis pegawai.
+type HakCuti struct {
+	ID               int64     `json:"id"`
+	Jenis_Pegawai_ID int64     `gorm:"uniqueIndex:idx_hak_cuti" json:"jenis_pegawai_id"`
+	Jenis_Cuti_ID    int64     `gorm:"uniqueIndex:idx_hak_cuti" json:"jenis_cuti_id"`
+	Jumlah_Hari      int       `json:"jumlah_hari"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+func (HakCuti) TableName() string {
+	return "hak_cuti"
+}
+
+// HariLibur is a public holiday; it is not counted as a leave day.
+type HariLibur struct {
+	ID         int64     `json:"id"`
+	Tanggal    string    `gorm:"size:10;uniqueIndex" json:"tanggal"`
+	Keterangan string    `json:"keterangan"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (HariLibur) TableName() string {
+	return "hari_libur"
+}
+
+// KepalaUnit names the employee who approves leave requests for a unit.
+type KepalaUnit struct {
+	Unit       string    `gorm:"primaryKey;size:100" json:"unit"`
+	Pegawai_ID int64     `json:"pegawai_id"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (KepalaUnit) TableName() string {
+	return "kepala_unit"
+}
+
+type CutiMasterHandler struct {
+	db *gorm.DB
+}
+
+func NewCutiMasterHandler(db *gorm.DB) *CutiMasterHandler {
+	return &CutiMasterHandler{db: db}
+}
+
+type JenisCutiRequest struct {
+	ID              string `param:"id"`
+	Jenis_Cuti      string `json:"jenis_cuti"`
+	Potong_Saldo    bool   `json:"potong_saldo"`
+	Maks_Carry_Over int    `json:"maks_carry_over"`
+}
+
+type HakCutiRequest struct {
+	Jenis_Pegawai_ID int64 `json:"jenis_pegawai_id"`
+	Jenis_Cuti_ID    int64 `json:"jenis_cuti_id"`
+	Jumlah_Hari      int   `json:"jumlah_hari"`
+}
+
+type HariLiburRequest struct {
+	ID         string `param:"id"`
+	Tanggal    string `json:"tanggal"`
+	Keterangan string `json:"keterangan"`
+}
+
+type KepalaUnitRequest struct {
+	Unit       string `json:"unit"`
+	Pegawai_ID int64  `json:"pegawai_id"`
+}
+
+func (h *CutiMasterHandler) GetAllJenisCuti(ctx echo.Context) error {
+	jenisCuti := make([]*JenisCuti, 0)
+	if err := h.db.Find(&jenisCuti).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Jenis Cuti"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Jenis Cuti", "data": jenisCuti})
+}
+
+func (h *CutiMasterHandler) CreateJenisCuti(ctx echo.Context) error {
+	var input JenisCutiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	if input.Maks_Carry_Over < 0 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "maks_carry_over must not be negative"})
+	}
+
+	jenisCuti := &JenisCuti{
+		Jenis_Cuti:      input.Jenis_Cuti,
+		Potong_Saldo:    input.Potong_Saldo,
+		Maks_Carry_Over: input.Maks_Carry_Over,
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(jenisCuti).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "jenis_cuti", jenisCuti.ID, nil, jenisCuti)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Create Jenis Cuti"})
+	}
+
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Succesfully Create a Jenis Cuti", "data": jenisCuti})
+}
+
+func (h *CutiMasterHandler) UpdateJenisCuti(ctx echo.Context) error {
+	var input JenisCutiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	if input.Maks_Carry_Over < 0 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "maks_carry_over must not be negative"})
+	}
+
+	jenisCutiID, _ := strconv.Atoi(input.ID)
+	sebelum := new(JenisCuti)
+	if err := h.db.First(sebelum, jenisCutiID).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Jenis Cuti not found"})
+	}
+	updates := map[string]interface{}{
+		"jenis_cuti":      input.Jenis_Cuti,
+		"potong_saldo":    input.Potong_Saldo,
+		"maks_carry_over": input.Maks_Carry_Over,
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&JenisCuti{}).Where("id = ?", jenisCutiID).Updates(updates).Error; err != nil {
+			return err
+		}
+		sesudah := new(JenisCuti)
+		if err := tx.First(sesudah, jenisCutiID).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "jenis_cuti", jenisCutiID, sebelum, sesudah)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Update Jenis Cuti By ID", "error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Update Jenis Cuti By ID : %s", input.ID), "data": input})
+}
+
+func (h *CutiMasterHandler) DeleteJenisCuti(ctx echo.Context) error {
+	var input JenisCutiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	sebelum := new(JenisCuti)
+	if err := h.db.Where("id = ?", input.ID).First(sebelum).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Jenis Cuti not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(sebelum).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "jenis_cuti", sebelum.ID, sebelum, nil)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Delete Jenis Cuti By ID"})
+	}
+	return ctx.JSON(http.StatusNoContent, nil)
+}
+
+func (h *CutiMasterHandler) GetAllHakCuti(ctx echo.Context) error {
+	hakCuti := make([]*HakCuti, 0)
+	query := h.db.Model(&HakCuti{})
+	if jenisPegawaiID := ctx.QueryParam("jenis_pegawai_id"); jenisPegawaiID != "" {
+		query = query.Where("jenis_pegawai_id = ?", jenisPegawaiID)
+	}
+	if err := query.Find(&hakCuti).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Hak Cuti"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Hak Cuti", "data": hakCuti})
+}
+
+// SetHakCuti creates or replaces the entitlement for a jenis pegawai and leave
+// type. Balances that were already opened for the year are not changed.
+func (h *CutiMasterHandler) SetHakCuti(ctx echo.Context) error {
+	var input HakCutiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	if input.Jumlah_Hari < 0 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "jumlah_hari must not be negative"})
+	}
+	var count int64
+	if err := h.db.Model(&JenisCuti{}).Where("id = ?", input.Jenis_Cuti_ID).Count(&count).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Set Hak Cuti"})
+	}
+	if count == 0 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("Jenis Cuti %d not found", input.Jenis_Cuti_ID)})
+	}
+
+	hakCuti := &HakCuti{
+		Jenis_Pegawai_ID: input.Jenis_Pegawai_ID,
+		Jenis_Cuti_ID:    input.Jenis_Cuti_ID,
+		Jumlah_Hari:      input.Jumlah_Hari,
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var sebelum *HakCuti
+		lama := new(HakCuti)
+		err := tx.Where("jenis_pegawai_id = ? AND jenis_cuti_id = ?", input.Jenis_Pegawai_ID, input.Jenis_Cuti_ID).First(lama).Error
+		if err == nil {
+			sebelum = lama
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "jenis_pegawai_id"}, {Name: "jenis_cuti_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"jumlah_hari", "updated_at"}),
+		}).Create(hakCuti).Error
+		if err != nil {
+			return err
+		}
+		// The ID isn't reported back when an existing row was updated
+		if err := tx.Where("jenis_pegawai_id = ? AND jenis_cuti_id = ?", input.Jenis_Pegawai_ID, input.Jenis_Cuti_ID).First(hakCuti).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "hak_cuti", hakCuti.ID, sebelum, hakCuti)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Set Hak Cuti"})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Set Hak Cuti", "data": hakCuti})
+}
+
+func (h *CutiMasterHandler) GetAllHariLibur(ctx echo.Context) error {
+	hariLibur := make([]*HariLibur, 0)
+	query := h.db.Model(&HariLibur{}).Order("tanggal")
+	if tahun := ctx.QueryParam("tahun"); tahun != "" {
+		query = query.Where("tanggal LIKE ?", tahun+"-%")
+	}
+	if err := query.Find(&hariLibur).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Hari Libur"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Hari Libur", "data": hariLibur})
+}
+
+func (h *CutiMasterHandler) CreateHariLibur(ctx echo.Context) error {
+	var input HariLiburRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	if _, err := time.Parse(tglLayout, input.Tanggal); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "tanggal must be formatted as YYYY-MM-DD"})
+	}
+
+	hariLibur := &HariLibur{
+		Tanggal:    input.Tanggal,
+		Keterangan: input.Keterangan,
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(hariLibur).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "hari_libur", hariLibur.ID, nil, hariLibur)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Create Hari Libur"})
+	}
+
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Succesfully Create a Hari Libur", "data": hariLibur})
+}
+
+func (h *CutiMasterHandler) DeleteHariLibur(ctx echo.Context) error {
+	var input HariLiburRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	sebelum := new(HariLibur)
+	if err := h.db.Where("id = ?", input.ID).First(sebelum).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Hari Libur not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(sebelum).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "hari_libur", sebelum.ID, sebelum, nil)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Delete Hari Libur By ID"})
+	}
+	return ctx.JSON(http.StatusNoContent, nil)
+}
+
+func (h *CutiMasterHandler) GetAllKepalaUnit(ctx echo.Context) error {
+	kepalaUnit := make([]*KepalaUnit, 0)
+	if err := h.db.Find(&kepalaUnit).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Kepala Unit"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Kepala Unit", "data": kepalaUnit})
+}
+
+func (h *CutiMasterHandler) SetKepalaUnit(ctx echo.Context) error {
+	var input KepalaUnitRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	if input.Unit == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "unit is required"})
+	}
+	if _, err := findPegawai(h.db, strconv.FormatInt(input.Pegawai_ID, 10)); err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	kepalaUnit := &KepalaUnit{Unit: input.Unit, Pegawai_ID: input.Pegawai_ID}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var sebelum *KepalaUnit
+		lama := new(KepalaUnit)
+		err := tx.Where("unit = ?", input.Unit).First(lama).Error
+		if err == nil {
+			sebelum = lama
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err := tx.Save(kepalaUnit).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "kepala_unit", kepalaUnit.Unit, sebelum, kepalaUnit)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Set Kepala Unit"})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Set Kepala Unit", "data": kepalaUnit})
+}
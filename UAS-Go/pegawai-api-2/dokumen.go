@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"time"
+
+	"uas/audit"
+	"uas/storage"
+	"uas/upload"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// dokumenPrefix is the storage prefix of employee documents.
+const dokumenPrefix = "dokumen"
+
+// Dokumen is a file attached to an employee, such as an SK, diploma, contract
+// or ID scan. The type refers to the jenis_dokumen lookup.
+type Dokumen struct {
+	ID               int64          `json:"id"`
+	Pegawai_ID       int64          `gorm:"index" json:"pegawai_id"`
+	Jenis_Dokumen_ID int64          `json:"jenis_dokumen_id"`
+	Nomor            string         `json:"nomor"`
+	Tgl_Terbit       string         `json:"tgl_terbit"`
+	Tgl_Kadaluarsa   string         `json:"tgl_kadaluarsa"`
+	Nama_File        string         `json:"nama_file"` // file name as uploaded
+	File             string         `json:"-"`         // file name below dokumenPrefix
+	Ukuran           int64          `json:"ukuran"`
+	Checksum         string         `json:"checksum"`
+	Content_Type     string         `json:"content_type"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (Dokumen) TableName() string {
+	return "dokumen"
+}
+
+type DokumenHandler struct {
+	db    *gorm.DB
+	store storage.Storage
+}
+
+func NewDokumenHandler(db *gorm.DB, store storage.Storage) *DokumenHandler {
+	return &DokumenHandler{db: db, store: store}
+}
+
+type DokumenRequest struct {
+	Pegawai_ID       string `param:"id"`
+	ID               string `param:"dokumen_id"`
+	Jenis_Dokumen_ID int64  `form:"jenis_dokumen_id"`
+	Nomor            string `form:"nomor"`
+	Tgl_Terbit       string `form:"tgl_terbit"`
+	Tgl_Kadaluarsa   string `form:"tgl_kadaluarsa"`
+}
+
+func (r *DokumenRequest) validate(db *gorm.DB) (string, error) {
+	for name, value := range map[string]string{"tgl_terbit": r.Tgl_Terbit, "tgl_kadaluarsa": r.Tgl_Kadaluarsa} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse(tglLayout, value); err != nil {
+			return name + " must be formatted as YYYY-MM-DD", nil
+		}
+	}
+	if r.Tgl_Terbit != "" && r.Tgl_Kadaluarsa != "" && r.Tgl_Kadaluarsa < r.Tgl_Terbit {
+		return "tgl_kadaluarsa must not be before tgl_terbit", nil
+	}
+	var count int64
+	if err := db.Table("jenis_dokumen").Where("id = ?", r.Jenis_Dokumen_ID).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return fmt.Sprintf("Jenis Dokumen %d not found", r.Jenis_Dokumen_ID), nil
+	}
+	return "", nil
+}
+
+func (h *DokumenHandler) GetAllDokumen(ctx echo.Context) error {
+	var input DokumenRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	if _, err := findPegawai(h.db, input.Pegawai_ID); err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	dokumen := make([]*Dokumen, 0)
+	query := h.db.Where("pegawai_id = ?", input.Pegawai_ID)
+	if jenisDokumenID := ctx.QueryParam("jenis_dokumen_id"); jenisDokumenID != "" {
+		query = query.Where("jenis_dokumen_id = ?", jenisDokumenID)
+	}
+	if err := query.Find(&dokumen).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Dokumen"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Dokumen", "data": dokumen})
+}
+
+func (h *DokumenHandler) GetDokumenByID(ctx echo.Context) error {
+	var input DokumenRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	dokumen := new(Dokumen)
+	if err := h.db.Where("id = ? AND pegawai_id = ?", input.ID, input.Pegawai_ID).First(&dokumen).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Dokumen not found"})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Dokumen By ID : %s", input.ID), "data": dokumen})
+}
+
+// CreateDokumen accepts a multipart form with the metadata fields and the file
+// in the "file" field. Files are limited to UPLOAD_MAX_SIZE, like resumable
+// uploads.
+func (h *DokumenHandler) CreateDokumen(ctx echo.Context) error {
+	var input DokumenRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := findPegawai(h.db, input.Pegawai_ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	if msg, err := input.validate(h.db); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Dokumen"})
+	} else if msg != "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+	}
+
+	file, err := ctx.FormFile("file")
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "File is required"})
+	}
+	if _, maxSize, _ := uploadConfig(); file.Size > maxSize {
+		return ctx.JSON(http.StatusRequestEntityTooLarge, map[string]string{"message": fmt.Sprintf("File must not be larger than %d bytes", maxSize)})
+	}
+	saved, err := upload.Save(ctx.Request().Context(), h.store, file, dokumenPrefix)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to save Dokumen"})
+	}
+
+	dokumen := Dokumen{
+		Pegawai_ID:       pegawai.ID,
+		Jenis_Dokumen_ID: input.Jenis_Dokumen_ID,
+		Nomor:            input.Nomor,
+		Tgl_Terbit:       input.Tgl_Terbit,
+		Tgl_Kadaluarsa:   input.Tgl_Kadaluarsa,
+		Nama_File:        filepath.Base(file.Filename),
+		File:             saved.Name,
+		Ukuran:           saved.Size,
+		Checksum:         saved.Checksum,
+		Content_Type:     saved.ContentType,
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&dokumen).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "dokumen", dokumen.ID, nil, &dokumen)
+	})
+	if err != nil {
+		h.store.Delete(ctx.Request().Context(), path.Join(dokumenPrefix, saved.Name))
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Dokumen"})
+	}
+
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Dokumen created successfully", "data": dokumen})
+}
+
+func (h *DokumenHandler) DownloadDokumen(ctx echo.Context) error {
+	var input DokumenRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	dokumen := new(Dokumen)
+	if err := h.db.Where("id = ? AND pegawai_id = ?", input.ID, input.Pegawai_ID).First(&dokumen).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Dokumen not found"})
+	}
+
+	f, err := h.store.Open(ctx.Request().Context(), path.Join(dokumenPrefix, dokumen.File))
+	if errors.Is(err, storage.ErrNotExist) {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Dokumen file not found"})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to open Dokumen"})
+	}
+	defer f.Close()
+
+	// The stored type came from the client, so the content decides
+	contentType, err := jenisKonten(f)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to open Dokumen"})
+	}
+	header := ctx.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": dokumen.Nama_File}))
+	header.Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(ctx.Response(), ctx.Request(), dokumen.Nama_File, dokumen.UpdatedAt, f)
+	return nil
+}
+
+// dokumenTypes are the content types documents are served with; anything else
+// is served as application/octet-stream.
+var dokumenTypes = map[string]bool{
+	"application/pdf": true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+}
+
+// jenisKonten sniffs the content type of f from its first bytes and rewinds
+// it.
+func jenisKonten(f io.ReadSeeker) (string, error) {
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if contentType := http.DetectContentType(buf[:n]); dokumenTypes[contentType] {
+		return contentType, nil
+	}
+	return echo.MIMEOctetStream, nil
+}
+
+// DeleteDokumen soft deletes the document, as DeletePegawai does for the
+// documents of an employee. The file is kept.
+func (h *DokumenHandler) DeleteDokumen(ctx echo.Context) error {
+	var input DokumenRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	dokumen := new(Dokumen)
+	if err := h.db.Where("id = ? AND pegawai_id = ?", input.ID, input.Pegawai_ID).First(&dokumen).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Dokumen not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(dokumen).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "dokumen", dokumen.ID, dokumen, nil)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to delete Dokumen"})
+	}
+
+	return ctx.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestJenisKonten(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"pdf", []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n1 0 obj"), "application/pdf"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), "image/jpeg"},
+		{"html", []byte("<!DOCTYPE html><script>alert(1)</script>"), "application/octet-stream"},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), "application/octet-stream"},
+		{"text", []byte("plain text"), "application/octet-stream"},
+		{"empty", nil, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := bytes.NewReader(tt.content)
+			got, err := jenisKonten(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("jenisKonten() = %q; want %q", got, tt.want)
+			}
+			// The file must be served from the start
+			if rest, _ := io.ReadAll(f); !bytes.Equal(rest, tt.content) {
+				t.Errorf("jenisKonten() didn't rewind the file")
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"uas/audit"
+	"uas/storage"
+	"uas/upload"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// uploadPrefix holds the chunks of unfinished uploads, one directory per
+// session.
+const uploadPrefix = "upload"
+
+const (
+	defaultUploadKadaluarsa = "24h"
+	defaultUploadMaxSize    = 100 << 20
+	defaultUploadMaxChunk   = 8 << 20
+)
+
+// UploadSesi is a resumable upload of a large Dokumen. The client sends the
+// file in chunks with PATCH, each starting at the current Offset, and can
+// resume after a failure by asking for the Offset again. When Offset reaches
+// Ukuran the chunks are joined, verified against Checksum and attached to the
+// Pegawai as a Dokumen; if that fails, an empty PATCH at Offset retries it.
+type UploadSesi struct {
+	ID               string    `gorm:"size:32;primaryKey" json:"id"`
+	Pegawai_ID       int64     `gorm:"index" json:"pegawai_id"`
+	Jenis_Dokumen_ID int64     `json:"jenis_dokumen_id"`
+	Nomor            string    `json:"nomor"`
+	Tgl_Terbit       string    `json:"tgl_terbit"`
+	Tgl_Kadaluarsa   string    `json:"tgl_kadaluarsa"`
+	Nama_File        string    `json:"nama_file"`
+	Content_Type     string    `json:"content_type"`
+	Ukuran           int64     `json:"ukuran"`
+	Chunks           []string  `gorm:"serializer:json;type:text" json:"-"` // stored chunks accepted so far, in order
+	Offset           int64     `json:"offset"`
+	Checksum         string    `json:"checksum"` // expected hex encoded SHA-256
+	Kadaluarsa       time.Time `gorm:"index" json:"kadaluarsa"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+func (UploadSesi) TableName() string {
+	return "upload_sesi"
+}
+
+type UploadSesiRequest struct {
+	Pegawai_ID       string `param:"id"`
+	ID               string `param:"upload_id"`
+	Jenis_Dokumen_ID int64  `json:"jenis_dokumen_id"`
+	Nomor            string `json:"nomor"`
+	Tgl_Terbit       string `json:"tgl_terbit"`
+	Tgl_Kadaluarsa   string `json:"tgl_kadaluarsa"`
+	Nama_File        string `json:"nama_file"`
+	Content_Type     string `json:"content_type"`
+	Ukuran           int64  `json:"ukuran"`
+	Checksum         string `json:"checksum"`
+}
+
+// uploadConfig reads the limits of resumable uploads:
+//
+//	UPLOAD_KADALUARSA  how long a session lives without activity (default 24h)
+//	UPLOAD_MAX_SIZE    largest file in bytes (default 100 MiB)
+//	UPLOAD_MAX_CHUNK   largest chunk in bytes (default 8 MiB)
+func uploadConfig() (kadaluarsa time.Duration, maxSize, maxChunk int64) {
+	kadaluarsa, err := time.ParseDuration(getEnv("UPLOAD_KADALUARSA", defaultUploadKadaluarsa))
+	if err != nil || kadaluarsa <= 0 {
+		kadaluarsa, _ = time.ParseDuration(defaultUploadKadaluarsa)
+	}
+	maxSize, err = strconv.ParseInt(getEnv("UPLOAD_MAX_SIZE", ""), 10, 64)
+	if err != nil || maxSize <= 0 {
+		maxSize = defaultUploadMaxSize
+	}
+	maxChunk, err = strconv.ParseInt(getEnv("UPLOAD_MAX_CHUNK", ""), 10, 64)
+	if err != nil || maxChunk <= 0 {
+		maxChunk = defaultUploadMaxChunk
+	}
+	return kadaluarsa, maxSize, maxChunk
+}
+
+// findUploadSesi loads an upload session of the Pegawai, answering 404 for
+// unknown and 410 for expired sessions. It returns nil when a response has
+// been written.
+func (h *DokumenHandler) findUploadSesi(ctx echo.Context, input *UploadSesiRequest) (*UploadSesi, error) {
+	sesi := new(UploadSesi)
+	if err := h.db.Where("id = ? AND pegawai_id = ?", input.ID, input.Pegawai_ID).First(sesi).Error; err != nil {
+		return nil, ctx.JSON(http.StatusNotFound, map[string]string{"message": "Upload not found"})
+	}
+	if time.Now().After(sesi.Kadaluarsa) {
+		h.hapusUploadSesi(ctx.Request().Context(), sesi)
+		return nil, ctx.JSON(http.StatusGone, map[string]string{"message": "Upload has expired"})
+	}
+	return sesi, nil
+}
+
+// CreateUpload starts a resumable upload. The body carries the Dokumen fields
+// plus nama_file, ukuran and the SHA-256 checksum of the whole file.
+func (h *DokumenHandler) CreateUpload(ctx echo.Context) error {
+	var input UploadSesiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := findPegawai(h.db, input.Pegawai_ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	dokumenRequest := DokumenRequest{
+		Jenis_Dokumen_ID: input.Jenis_Dokumen_ID,
+		Nomor:            input.Nomor,
+		Tgl_Terbit:       input.Tgl_Terbit,
+		Tgl_Kadaluarsa:   input.Tgl_Kadaluarsa,
+	}
+	if msg, err := dokumenRequest.validate(h.db); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Upload"})
+	} else if msg != "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+	}
+
+	kadaluarsa, maxSize, maxChunk := uploadConfig()
+	namaFile := filepath.Base(input.Nama_File)
+	if input.Nama_File == "" || namaFile == "." || namaFile == "/" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "nama_file is required"})
+	}
+	if input.Ukuran <= 0 || input.Ukuran > maxSize {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("ukuran must be between 1 and %d bytes", maxSize)})
+	}
+	checksum := strings.ToLower(input.Checksum)
+	if b, err := hex.DecodeString(checksum); err != nil || len(b) != 32 {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "checksum must be a hex encoded SHA-256"})
+	}
+	contentType := input.Content_Type
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(namaFile))
+	}
+
+	id, err := uploadID()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Upload"})
+	}
+	sesi := UploadSesi{
+		ID:               id,
+		Pegawai_ID:       pegawai.ID,
+		Jenis_Dokumen_ID: input.Jenis_Dokumen_ID,
+		Nomor:            input.Nomor,
+		Tgl_Terbit:       input.Tgl_Terbit,
+		Tgl_Kadaluarsa:   input.Tgl_Kadaluarsa,
+		Nama_File:        namaFile,
+		Content_Type:     contentType,
+		Ukuran:           input.Ukuran,
+		Checksum:         checksum,
+		Kadaluarsa:       time.Now().Add(kadaluarsa),
+	}
+	if err := h.db.Create(&sesi).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Upload"})
+	}
+
+	ctx.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/pegawai/%d/dokumen/upload/%s", pegawai.ID, sesi.ID))
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Upload created successfully", "data": sesi, "max_chunk": maxChunk})
+}
+
+// GetUpload returns the session, most importantly the Offset to resume from.
+// The offset is also sent in the Upload-Offset header.
+func (h *DokumenHandler) GetUpload(ctx echo.Context) error {
+	var input UploadSesiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	sesi, err := h.findUploadSesi(ctx, &input)
+	if sesi == nil {
+		return err
+	}
+
+	ctx.Response().Header().Set("Upload-Offset", strconv.FormatInt(sesi.Offset, 10))
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get Upload", "data": sesi})
+}
+
+// PatchUpload appends the request body to the upload. The Upload-Offset
+// header must equal the current offset, so a chunk is never written twice.
+// The chunk that completes the file creates the Dokumen and answers 201. An
+// empty PATCH once all chunks are in retries a completion that failed.
+func (h *DokumenHandler) PatchUpload(ctx echo.Context) error {
+	// Only the path is bound; the body is the raw chunk.
+	var input UploadSesiRequest
+	if err := (&echo.DefaultBinder{}).BindPathParams(ctx, &input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	sesi, err := h.findUploadSesi(ctx, &input)
+	if sesi == nil {
+		return err
+	}
+
+	offset, err := strconv.ParseInt(ctx.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Upload-Offset header is required"})
+	}
+	if offset != sesi.Offset {
+		ctx.Response().Header().Set("Upload-Offset", strconv.FormatInt(sesi.Offset, 10))
+		return ctx.JSON(http.StatusConflict, map[string]interface{}{"message": "Upload-Offset does not match", "data": sesi})
+	}
+
+	length := ctx.Request().ContentLength
+	if sesi.Offset == sesi.Ukuran {
+		if length != 0 {
+			return ctx.JSON(http.StatusRequestEntityTooLarge, map[string]string{"message": "Upload is complete, send an empty PATCH to retry completing it"})
+		}
+		return h.selesaikanUpload(ctx, sesi)
+	}
+
+	kadaluarsa, _, maxChunk := uploadConfig()
+	remaining := sesi.Ukuran - sesi.Offset
+	if remaining < maxChunk {
+		maxChunk = remaining
+	}
+	if length <= 0 || length > maxChunk {
+		return ctx.JSON(http.StatusRequestEntityTooLarge, map[string]string{"message": fmt.Sprintf("Chunk must be between 1 and %d bytes with a Content-Length", maxChunk)})
+	}
+
+	// Chunk names sort by offset; the random suffix keeps a chunk of a losing
+	// concurrent request from overwriting the winning one.
+	suffix, err := uploadID()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to save chunk"})
+	}
+	chunk := path.Join(uploadPrefix, sesi.ID, fmt.Sprintf("%020d-%s", offset, suffix[:8]))
+	body := io.LimitReader(ctx.Request().Body, length)
+	if err := h.store.Save(ctx.Request().Context(), chunk, body, length, "application/octet-stream"); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to save chunk"})
+	}
+	info, err := h.store.Stat(ctx.Request().Context(), chunk)
+	if err != nil || info.Size != length {
+		h.store.Delete(ctx.Request().Context(), chunk)
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Chunk is shorter than its Content-Length"})
+	}
+
+	// Only the request that moves the offset records its chunk, so a losing
+	// chunk is never joined even while it still exists.
+	update := UploadSesi{
+		Offset:     offset + length,
+		Chunks:     append(sesi.Chunks, chunk),
+		Kadaluarsa: time.Now().Add(kadaluarsa),
+	}
+	result := h.db.Model(sesi).Where("`offset` = ?", offset).Select("offset", "chunks", "kadaluarsa").Updates(&update)
+	if result.Error != nil || result.RowsAffected == 0 {
+		h.store.Delete(ctx.Request().Context(), chunk)
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": "Upload was modified concurrently, get the offset and retry"})
+	}
+	sesi.Offset, sesi.Chunks = update.Offset, update.Chunks
+
+	ctx.Response().Header().Set("Upload-Offset", strconv.FormatInt(sesi.Offset, 10))
+	if sesi.Offset < sesi.Ukuran {
+		return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Chunk saved successfully", "data": sesi})
+	}
+	return h.selesaikanUpload(ctx, sesi)
+}
+
+// selesaikanUpload joins the chunks of a complete upload into a Dokumen. On
+// failure the session is kept, so the client can retry.
+func (h *DokumenHandler) selesaikanUpload(ctx echo.Context, sesi *UploadSesi) error {
+	c := ctx.Request().Context()
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(h.joinChunks(c, sesi.Chunks, pw))
+	}()
+	saved, err := upload.SaveReader(c, h.store, pr, sesi.Ukuran, sesi.Nama_File, sesi.Content_Type, dokumenPrefix)
+	pr.CloseWithError(err)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to complete Upload"})
+	}
+
+	if saved.Size != sesi.Ukuran || saved.Checksum != sesi.Checksum {
+		// The chunks can't be trusted; the client has to start over.
+		h.store.Delete(c, path.Join(dokumenPrefix, saved.Name))
+		h.hapusUploadSesi(c, sesi)
+		return ctx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"message": "Checksum mismatch, upload discarded",
+			"data":    map[string]string{"expected": sesi.Checksum, "actual": saved.Checksum},
+		})
+	}
+
+	dokumen := Dokumen{
+		Pegawai_ID:       sesi.Pegawai_ID,
+		Jenis_Dokumen_ID: sesi.Jenis_Dokumen_ID,
+		Nomor:            sesi.Nomor,
+		Tgl_Terbit:       sesi.Tgl_Terbit,
+		Tgl_Kadaluarsa:   sesi.Tgl_Kadaluarsa,
+		Nama_File:        sesi.Nama_File,
+		File:             saved.Name,
+		Ukuran:           saved.Size,
+		Checksum:         saved.Checksum,
+		Content_Type:     saved.ContentType,
+	}
+	// Deleting the session first makes a concurrent retry lose.
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(sesi)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errUploadSelesai
+		}
+		if err := tx.Create(&dokumen).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "dokumen", dokumen.ID, nil, &dokumen)
+	})
+	if err != nil {
+		h.store.Delete(c, path.Join(dokumenPrefix, saved.Name))
+		if errors.Is(err, errUploadSelesai) {
+			return ctx.JSON(http.StatusConflict, map[string]string{"message": "Upload has already been completed"})
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Dokumen"})
+	}
+	h.hapusChunks(c, sesi)
+
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Dokumen created successfully", "data": dokumen})
+}
+
+func (h *DokumenHandler) joinChunks(ctx context.Context, chunks []string, w io.Writer) error {
+	for _, chunk := range chunks {
+		f, err := h.store.Open(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUpload aborts an upload and removes its chunks.
+func (h *DokumenHandler) DeleteUpload(ctx echo.Context) error {
+	var input UploadSesiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	sesi, err := h.findUploadSesi(ctx, &input)
+	if sesi == nil {
+		return err
+	}
+	if err := h.hapusUploadSesi(ctx.Request().Context(), sesi); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to delete Upload"})
+	}
+
+	return ctx.JSON(http.StatusNoContent, nil)
+}
+
+// hapusUploadSesi removes a session and its chunks.
+func (h *DokumenHandler) hapusUploadSesi(ctx context.Context, sesi *UploadSesi) error {
+	if err := h.db.Delete(sesi).Error; err != nil {
+		return err
+	}
+	return h.hapusChunks(ctx, sesi)
+}
+
+// hapusChunks removes every chunk stored for a session, including those of
+// requests that lost a race.
+func (h *DokumenHandler) hapusChunks(ctx context.Context, sesi *UploadSesi) error {
+	chunks, err := h.store.List(ctx, path.Join(uploadPrefix, sesi.ID)+"/")
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if err := h.store.Delete(ctx, chunk); err != nil && !errors.Is(err, storage.ErrNotExist) {
+			log.Printf("failed to remove upload chunk %s: %v", chunk, err)
+		}
+	}
+	return nil
+}
+
+// HapusUploadKadaluarsa removes expired sessions every interval until ctx is
+// done.
+func (h *DokumenHandler) HapusUploadKadaluarsa(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var expired []*UploadSesi
+		if err := h.db.Where("kadaluarsa < ?", time.Now()).Find(&expired).Error; err != nil {
+			log.Printf("failed to find expired uploads: %v", err)
+			continue
+		}
+		for _, sesi := range expired {
+			if err := h.hapusUploadSesi(ctx, sesi); err != nil {
+				log.Printf("failed to remove expired upload %s: %v", sesi.ID, err)
+			}
+		}
+	}
+}
+
+// errUploadSelesai reports that another request completed the upload first.
+var errUploadSelesai = errors.New("upload already completed")
+
+func uploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
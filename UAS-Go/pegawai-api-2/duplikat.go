@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"uas/audit"
+	"uas/auth"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// nikIndex is the unique index on the blind index of the NIK. The NIK itself
+// is encrypted with a random nonce, so the old index on it, nikIndexLama,
+// can't detect duplicates anymore.
+const (
+	nikIndex     = "idx_pegawai_nik_index"
+	nikIndexLama = "idx_pegawai_nik"
+)
+
+// defaultSkorDuplikat is the minimum name similarity, between 0 and 1, for two
+// employees born on the same date and place to be reported as duplicates.
+const defaultSkorDuplikat = 0.85
+
+// ensureNIKUniqueIndex encrypts plaintext NIKs left from before encryption and
+// creates the unique index on pegawai.nik_index. Existing duplicates would
+// make the index creation fail, so in that case it only logs a warning;
+// resolve them with GET /pegawai/duplikat and POST /pegawai/:id/merge and
+// restart.
+func ensureNIKUniqueIndex(db *gorm.DB) error {
+	if _, err := simpanNIK(db, db.Where("nik IS NOT NULL AND nik_index IS NULL"), 500); err != nil {
+		return err
+	}
+	if db.Migrator().HasIndex(&Pegawai{}, nikIndex) {
+		return nil
+	}
+	if db.Migrator().HasIndex(&Pegawai{}, nikIndexLama) {
+		if err := db.Migrator().DropIndex(&Pegawai{}, nikIndexLama); err != nil {
+			return err
+		}
+	}
+	var duplicates int64
+	err := db.Unscoped().Model(&Pegawai{}).
+		Where("nik_index IS NOT NULL").
+		Group("nik_index").Having("COUNT(*) > 1").
+		Count(&duplicates).Error
+	if err != nil {
+		return err
+	}
+	if duplicates > 0 {
+		log.Printf("WARNING: %d NIK values are used by more than one pegawai; unique index %s not created", duplicates, nikIndex)
+		return nil
+	}
+	return db.Exec("CREATE UNIQUE INDEX " + nikIndex + " ON pegawai (nik_index)").Error
+}
+
+// findPegawaiByNIK returns the employee, including soft-deleted ones, that
+// already uses nik, ignoring the employee with id exceptID.
+func findPegawaiByNIK(db *gorm.DB, nik string, exceptID int64) (*Pegawai, error) {
+	existing := new(Pegawai)
+	err := db.Unscoped().Where("nik_index = ? AND id <> ?", indeksNIK(nik), exceptID).First(existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return existing, err
+}
+
+// nikConflict writes the 409 response naming the employee that holds the NIK.
+func nikConflict(ctx echo.Context, existing *Pegawai) error {
+	return ctx.JSON(http.StatusConflict, map[string]interface{}{
+		"message": "NIK is already registered to Pegawai " + strconv.FormatInt(existing.ID, 10),
+		"data": map[string]interface{}{
+			"id":           existing.ID,
+			"nip":          existing.NIP,
+			"nama_pegawai": existing.Nama_Pegawai,
+			"deleted":      existing.DeletedAt.Valid,
+		},
+	})
+}
+
+// KandidatDuplikat is a group of employees that are likely the same person.
+type KandidatDuplikat struct {
+	Alasan  string     `json:"alasan"` // "nik" or "nama"
+	Skor    float64    `json:"skor"`   // name similarity, 1 for NIK matches
+	Pegawai []*Pegawai `json:"pegawai"`
+}
+
+type MergePegawaiRequest struct {
+	ID        string `param:"id"`
+	Sumber_ID int64  `json:"sumber_id"`
+}
+
+// GetDuplikatPegawai lists likely duplicates: employees sharing a NIK, and
+// pairs born on the same date and place whose names are at least ?skor=
+// similar.
+func (h *PegawaiHandler) GetDuplikatPegawai(ctx echo.Context) error {
+	skor := defaultSkorDuplikat
+	if param := ctx.QueryParam("skor"); param != "" {
+		var err error
+		if skor, err = strconv.ParseFloat(param, 64); err != nil || skor <= 0 || skor > 1 {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "skor must be a number between 0 and 1"})
+		}
+	}
+
+	kandidat := make([]*KandidatDuplikat, 0)
+
+	var indexes []string
+	err := h.db.Model(&Pegawai{}).
+		Where("nik_index IS NOT NULL").
+		Group("nik_index").Having("COUNT(*) > 1").
+		Pluck("nik_index", &indexes).Error
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Duplikat Pegawai"})
+	}
+	for _, index := range indexes {
+		pegawai := make([]*Pegawai, 0)
+		if err := h.db.Where("nik_index = ?", index).Order("id").Find(&pegawai).Error; err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Duplikat Pegawai"})
+		}
+		kandidat = append(kandidat, &KandidatDuplikat{Alasan: "nik", Skor: 1, Pegawai: pegawai})
+	}
+
+	type kelahiran struct {
+		Tgl_Lahir string
+		Tpt_Lahir string
+	}
+	var groups []kelahiran
+	err = h.db.Model(&Pegawai{}).
+		Select("tgl_lahir, LOWER(TRIM(tpt_lahir)) AS tpt_lahir").
+		Where("tgl_lahir <> ''").
+		Group("tgl_lahir, LOWER(TRIM(tpt_lahir))").Having("COUNT(*) > 1").
+		Scan(&groups).Error
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Duplikat Pegawai"})
+	}
+	for _, g := range groups {
+		pegawai := make([]*Pegawai, 0)
+		err := h.db.Where("tgl_lahir = ? AND LOWER(TRIM(tpt_lahir)) = ?", g.Tgl_Lahir, g.Tpt_Lahir).Order("id").Find(&pegawai).Error
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Duplikat Pegawai"})
+		}
+		for i := 0; i < len(pegawai); i++ {
+			for j := i + 1; j < len(pegawai); j++ {
+				a, b := pegawai[i], pegawai[j]
+				if a.NIK != "" && a.NIK == b.NIK {
+					continue // already reported as a NIK match
+				}
+				if s := kemiripanNama(a.Nama_Pegawai, b.Nama_Pegawai); s >= skor {
+					kandidat = append(kandidat, &KandidatDuplikat{Alasan: "nama", Skor: s, Pegawai: []*Pegawai{a, b}})
+				}
+			}
+		}
+	}
+
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": "Succesfully Get Duplikat Pegawai", "data": kandidat})
+}
+
+// MergePegawai folds the employee sumber_id into :id. Sub-resources of the
+// source are moved to the target, empty fields of the target are filled from
+// the source, and the source is soft-deleted with its NIK cleared so that the
+// NIK unique index can be created.
+func (h *PegawaiHandler) MergePegawai(ctx echo.Context) error {
+	var input MergePegawaiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	target, err := findPegawai(h.db, input.ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	if input.Sumber_ID == target.ID {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Cannot merge a Pegawai into itself"})
+	}
+	sumber, err := findPegawai(h.db, strconv.FormatInt(input.Sumber_ID, 10))
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	lamaSumber, lamaTarget := *sumber, *target
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := mergeSubResources(tx, sumber.ID, target.ID); err != nil {
+			return err
+		}
+
+		fillString := func(dst *string, src string) {
+			if *dst == "" {
+				*dst = src
+			}
+		}
+		fillID := func(dst *int64, src int64) {
+			if *dst == 0 {
+				*dst = src
+			}
+		}
+		fillString(&target.NIK, sumber.NIK)
+		fillString(&target.Unit, sumber.Unit)
+		fillString(&target.Sub_Unit, sumber.Sub_Unit)
+		fillString(&target.Tgl_Lahir, sumber.Tgl_Lahir)
+		fillString(&target.Tpt_Lahir, sumber.Tpt_Lahir)
+		fillString(&target.Tgl_Masuk, sumber.Tgl_Masuk)
+		fillString(&target.Alamat, sumber.Alamat)
+		fillString(&target.Gambar, sumber.Gambar)
+		fillID(&target.Jenis_Pegawai_ID, sumber.Jenis_Pegawai_ID)
+		fillID(&target.Status_Pegawai_ID, sumber.Status_Pegawai_ID)
+		fillID(&target.Pendidikan_ID, sumber.Pendidikan_ID)
+		fillID(&target.Jenkel_ID, sumber.Jenkel_ID)
+		fillID(&target.Agama_ID, sumber.Agama_ID)
+
+		if err := tx.Model(sumber).UpdateColumns(map[string]interface{}{"nik": nil, "nik_index": nil}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(sumber).Error; err != nil {
+			return err
+		}
+		if err := tx.Save(target).Error; err != nil {
+			return err
+		}
+		if err := syncPendidikan(tx, target.ID); err != nil {
+			return err
+		}
+		if err := audit.Catat(tx, ctx, "pegawai", sumber.ID, &lamaSumber, nil); err != nil {
+			return err
+		}
+		if err := tx.First(target, target.ID).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "pegawai", target.ID, &lamaTarget, target)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to merge Pegawai", "error": err.Error()})
+	}
+
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": "Pegawai merged successfully", "data": target})
+}
+
+// mergeSubResources moves every row belonging to employee from over to
+// employee to. Rows keyed by day or year that both employees have are
+// combined instead of moved.
+func mergeSubResources(tx *gorm.DB, from, to int64) error {
+	for _, model := range []interface{}{&Keluarga{}, &RiwayatPendidikan{}, &Dokumen{}, &RiwayatStatus{}, &Cuti{}, &Pengajuan{}, &UploadSesi{}} {
+		if err := tx.Model(model).Where("pegawai_id = ?", from).Update("pegawai_id", to).Error; err != nil {
+			return err
+		}
+	}
+
+	// Attendance: the target's record wins when both checked in on a day.
+	// MySQL can't delete from a table a subquery reads, so the days are
+	// fetched first.
+	var tanggal []string
+	if err := tx.Model(&Presensi{}).Where("pegawai_id = ?", to).Pluck("tanggal", &tanggal).Error; err != nil {
+		return err
+	}
+	if len(tanggal) > 0 {
+		if err := tx.Where("pegawai_id = ? AND tanggal IN ?", from, tanggal).Delete(&Presensi{}).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&Presensi{}).Where("pegawai_id = ?", from).Update("pegawai_id", to).Error; err != nil {
+		return err
+	}
+
+	// Leave balances: days used and carried over are added to the target's.
+	saldo := make([]*SaldoCuti, 0)
+	if err := tx.Where("pegawai_id = ?", from).Find(&saldo).Error; err != nil {
+		return err
+	}
+	for _, s := range saldo {
+		existing := new(SaldoCuti)
+		err := tx.Where("pegawai_id = ? AND jenis_cuti_id = ? AND tahun = ?", to, s.Jenis_Cuti_ID, s.Tahun).First(existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := tx.Model(s).Update("pegawai_id", to).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = tx.Model(existing).Updates(map[string]interface{}{
+			"terpakai":   existing.Terpakai + s.Terpakai,
+			"carry_over": existing.Carry_Over + s.Carry_Over,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(s).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(&KepalaUnit{}).Where("pegawai_id = ?", from).Update("pegawai_id", to).Error; err != nil {
+		return err
+	}
+	// The user account moves along unless the target already has one.
+	var akun int64
+	if err := tx.Model(&auth.User{}).Where("pegawai_id = ?", to).Count(&akun).Error; err != nil {
+		return err
+	}
+	if akun == 0 {
+		if err := tx.Model(&auth.User{}).Where("pegawai_id = ?", from).Update("pegawai_id", to).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&Cuti{}).Where("diputus_oleh = ?", from).Update("diputus_oleh", to).Error
+}
+
+// kemiripanNama returns the similarity of two names between 0 and 1, based on
+// the Levenshtein distance of their normalised forms.
+func kemiripanNama(a, b string) float64 {
+	a, b = normalisasiNama(a), normalisasiNama(b)
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func normalisasiNama(nama string) string {
+	return strings.Join(strings.Fields(strings.ToLower(nama)), " ")
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"uas/audit"
+	"uas/gambar"
+	"uas/storage"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// errNoGambar is returned by stageGambar when the request carries no photo.
+var errNoGambar = errors.New("no gambar in request")
+
+// stageGambar processes the photo sent with the request into the staging
+// area, either as the multipart file field "gambar" or as a base64 data URI
+// in the gambar field of the JSON body. Any other gambar value, such as the
+// stored file name echoed back by a client, is ignored. The caller commits
+// the photo with commitGambar as the last step of the transaction that
+// references it, and discards it when that fails.
+func (h *PegawaiHandler) stageGambar(ctx echo.Context, request *PegawaiRequest) (*gambar.Staged, error) {
+	if strings.HasPrefix(ctx.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		file, err := ctx.FormFile("gambar")
+		if errors.Is(err, http.ErrMissingFile) {
+			return nil, errNoGambar
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", gambar.ErrInvalid, err)
+		}
+		return gambar.Stage(ctx.Request().Context(), h.store, file)
+	}
+
+	if !strings.HasPrefix(request.Gambar, "data:") {
+		return nil, errNoGambar
+	}
+	meta, data, ok := strings.Cut(strings.TrimPrefix(request.Gambar, "data:"), ",")
+	if !ok || !strings.HasSuffix(meta, ";base64") {
+		return nil, fmt.Errorf("%w: gambar must be a base64 data URI", gambar.ErrInvalid)
+	}
+	// The declared media type is not trusted; gambar sniffs the content.
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))
+	staged, err := gambar.StageReader(ctx.Request().Context(), h.store, decoder)
+	var corrupt base64.CorruptInputError
+	if errors.As(err, &corrupt) {
+		return nil, fmt.Errorf("%w: gambar is not valid base64", gambar.ErrInvalid)
+	}
+	return staged, err
+}
+
+// commitGambar moves a staged photo, if any, to its final name.
+func commitGambar(ctx echo.Context, staged *gambar.Staged) error {
+	if staged == nil {
+		return nil
+	}
+	return staged.Commit(ctx.Request().Context())
+}
+
+// discardGambar removes a staged photo, if any, after its transaction failed.
+func discardGambar(ctx echo.Context, staged *gambar.Staged) {
+	if staged != nil {
+		staged.Discard(ctx.Request().Context())
+	}
+}
+
+// hapusGambar removes a photo that is no longer referenced. Failures only leave
+// an orphaned file behind, so they are logged rather than returned.
+func (h *PegawaiHandler) hapusGambar(ctx echo.Context, name string) {
+	if name == "" {
+		return
+	}
+	if err := gambar.Delete(ctx.Request().Context(), h.store, name); err != nil && !errors.Is(err, storage.ErrNotExist) {
+		log.Printf("failed to remove gambar %s: %v", name, err)
+	}
+}
+
+// gambarError writes the response for an error returned by stageGambar.
+func gambarError(ctx echo.Context, err error) error {
+	if errors.Is(err, gambar.ErrInvalid) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to save Gambar"})
+}
+
+// kecualiSigned skips mw for requests carrying a photo URL signature, which
+// GetGambar verifies instead.
+func kecualiSigned(mw echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		checked := mw(next)
+		return func(ctx echo.Context) error {
+			if gambar.Signed(ctx.QueryParams()) {
+				return next(ctx)
+			}
+			return checked(ctx)
+		}
+	}
+}
+
+// GetGambar streams the photo of a Pegawai. ?size= selects a thumbnail;
+// requests carrying a signature from GetGambarURL must have a valid,
+// unexpired one.
+func (h *PegawaiHandler) GetGambar(ctx echo.Context) error {
+	if gambar.Signed(ctx.QueryParams()) && !gambar.Verify(ctx.Param("id"), ctx.QueryParams()) {
+		return ctx.JSON(http.StatusForbidden, map[string]string{"message": "Invalid or expired signature"})
+	}
+	pegawai, err := findPegawai(h.db, ctx.Param("id"))
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	if pegawai.Gambar == "" {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pegawai has no Gambar"})
+	}
+
+	err = gambar.Serve(ctx.Response(), ctx.Request(), h.store, pegawai.Gambar, ctx.QueryParam("size"))
+	if errors.Is(err, gambar.ErrSize) {
+		return ctx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "Unknown size", "data": gambar.SizeNames()})
+	}
+	if errors.Is(err, storage.ErrNotExist) {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Gambar file not found"})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Gambar"})
+	}
+	return nil
+}
+
+// GetGambarURL returns a signed photo URL that stays valid for ?ttl= seconds
+// (default 15 minutes, at most a day).
+func (h *PegawaiHandler) GetGambarURL(ctx echo.Context) error {
+	size := ctx.QueryParam("size")
+	if _, ok := gambar.Key("", size); !ok {
+		return ctx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "Unknown size", "data": gambar.SizeNames()})
+	}
+	ttl := 15 * time.Minute
+	if value := ctx.QueryParam("ttl"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 || seconds > 86400 {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "ttl must be between 1 and 86400 seconds"})
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	pegawai, err := findPegawai(h.db, ctx.Param("id"))
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	id := strconv.FormatInt(pegawai.ID, 10)
+	expires := time.Now().Add(ttl)
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get Gambar URL", "data": map[string]interface{}{
+		"url":        "/pegawai/" + id + "/gambar?" + gambar.SignedQuery(id, size, expires),
+		"expires_at": expires,
+	}})
+}
+
+// UpdateGambar replaces only the photo of a Pegawai.
+func (h *PegawaiHandler) UpdateGambar(ctx echo.Context) error {
+	request := new(PegawaiRequest)
+	if err := ctx.Bind(request); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := findPegawai(h.db, request.ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	staged, err := h.stageGambar(ctx, request)
+	if errors.Is(err, errNoGambar) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Gambar is required"})
+	}
+	if err != nil {
+		return gambarError(ctx, err)
+	}
+
+	lama := *pegawai
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(pegawai).Update("gambar", staged.Name).Error; err != nil {
+			return err
+		}
+		if err := audit.Catat(tx, ctx, "pegawai", pegawai.ID, &lama, pegawai); err != nil {
+			return err
+		}
+		return commitGambar(ctx, staged)
+	})
+	if err != nil {
+		discardGambar(ctx, staged)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Gambar"})
+	}
+	h.hapusGambar(ctx, lama.Gambar)
+
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": "Gambar updated successfully", "data": pegawai})
+}
+
+// DeleteGambar removes the photo of a Pegawai.
+func (h *PegawaiHandler) DeleteGambar(ctx echo.Context) error {
+	pegawai, err := findPegawai(h.db, ctx.Param("id"))
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	if pegawai.Gambar == "" {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pegawai has no Gambar"})
+	}
+
+	lama := *pegawai
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(pegawai).Update("gambar", "").Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "pegawai", pegawai.ID, &lama, pegawai)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to delete Gambar"})
+	}
+	h.hapusGambar(ctx, lama.Gambar)
+
+	return ctx.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"uas/audit"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Allowed values for Keluarga.Hubungan.
+var hubunganKeluarga = map[string]bool{
+	"suami": true,
+	"istri": true,
+	"anak":  true,
+	"ayah":  true,
+	"ibu":   true,
+}
+
+type Keluarga struct {
+	ID         int64          `json:"id"`
+	Pegawai_ID int64          `gorm:"index" json:"pegawai_id"`
+	Nama       string         `json:"nama"`
+	Hubungan   string         `json:"hubungan"`
+	NIK        string         `gorm:"column:nik;serializer:enkripsi" json:"nik"`
+	Tgl_Lahir  string         `json:"tgl_lahir"`
+	Jenkel_ID  int64          `json:"jenkel_id"`
+	Tanggungan bool           `json:"tanggungan"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (Keluarga) TableName() string {
+	return "keluarga"
+}
+
+type KeluargaHandler struct {
+	db *gorm.DB
+}
+
+func NewKeluargaHandler(db *gorm.DB) *KeluargaHandler {
+	return &KeluargaHandler{db: db}
+}
+
+type KeluargaRequest struct {
+	Pegawai_ID string `param:"id"`
+	ID         string `param:"keluarga_id"`
+	Nama       string `json:"nama"`
+	Hubungan   string `json:"hubungan"`
+	NIK        string `json:"nik"`
+	Tgl_Lahir  string `json:"tgl_lahir"`
+	Jenkel_ID  int64  `json:"jenkel_id"`
+	Tanggungan bool   `json:"tanggungan"`
+}
+
+// nikPattern is the format of a NIK, the 16 digit national identity number.
+var nikPattern = regexp.MustCompile(`^[0-9]{16}$`)
+
+// validate checks the request against the lookup tables and returns a message
+// suitable for a 400 response, or an empty string when the request is valid.
+func (r *KeluargaRequest) validate(db *gorm.DB) (string, error) {
+	if r.Nama == "" {
+		return "nama is required", nil
+	}
+	if !hubunganKeluarga[r.Hubungan] {
+		return fmt.Sprintf("Invalid hubungan %q", r.Hubungan), nil
+	}
+	// Young children may not have a NIK yet
+	if r.NIK != "" && !nikPattern.MatchString(r.NIK) {
+		return "nik must be 16 digits", nil
+	}
+	if r.Tgl_Lahir != "" {
+		if _, err := time.Parse(tglLayout, r.Tgl_Lahir); err != nil {
+			return "tgl_lahir must be formatted as YYYY-MM-DD", nil
+		}
+	}
+	var count int64
+	if err := db.Table("jenis_kelamin").Where("id = ?", r.Jenkel_ID).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return fmt.Sprintf("Jenis Kelamin %d not found", r.Jenkel_ID), nil
+	}
+	return "", nil
+}
+
+// findPegawai loads the parent employee of a sub-resource. Soft-deleted
+// employees are treated as missing.
+func findPegawai(db *gorm.DB, id string) (*Pegawai, error) {
+	pegawai := new(Pegawai)
+	if err := db.Where("id = ?", id).First(pegawai).Error; err != nil {
+		return nil, err
+	}
+	return pegawai, nil
+}
+
+// pegawaiLookupError turns the error from findPegawai into a response.
+func pegawaiLookupError(ctx echo.Context, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pegawai not found"})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Pegawai"})
+}
+
+func (h *KeluargaHandler) GetAllKeluarga(ctx echo.Context) error {
+	var input KeluargaRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	if _, err := findPegawai(h.db, input.Pegawai_ID); err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	keluarga := make([]*Keluarga, 0)
+	if err := h.db.Where("pegawai_id = ?", input.Pegawai_ID).Find(&keluarga).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Keluarga"})
+	}
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Keluarga", "data": keluarga})
+}
+
+func (h *KeluargaHandler) GetKeluargaByID(ctx echo.Context) error {
+	var input KeluargaRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	keluarga := new(Keluarga)
+	if err := h.db.Where("id = ? AND pegawai_id = ?", input.ID, input.Pegawai_ID).First(&keluarga).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Keluarga not found"})
+	}
+
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Keluarga By ID : %s", input.ID), "data": keluarga})
+}
+
+func (h *KeluargaHandler) CreateKeluarga(ctx echo.Context) error {
+	var input KeluargaRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := findPegawai(h.db, input.Pegawai_ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	if msg, err := input.validate(h.db); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Keluarga"})
+	} else if msg != "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+	}
+
+	keluarga := Keluarga{
+		Pegawai_ID: pegawai.ID,
+		Nama:       input.Nama,
+		Hubungan:   input.Hubungan,
+		NIK:        input.NIK,
+		Tgl_Lahir:  input.Tgl_Lahir,
+		Jenkel_ID:  input.Jenkel_ID,
+		Tanggungan: input.Tanggungan,
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&keluarga).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "keluarga", keluarga.ID, nil, &keluarga)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Keluarga"})
+	}
+
+	return tampil(h.db, ctx, http.StatusCreated, map[string]interface{}{"message": "Keluarga created successfully", "data": keluarga})
+}
+
+func (h *KeluargaHandler) UpdateKeluarga(ctx echo.Context) error {
+	var input KeluargaRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	if _, err := strconv.ParseInt(input.ID, 10, 64); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid ID"})
+	}
+	if _, err := findPegawai(h.db, input.Pegawai_ID); err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	keluarga := new(Keluarga)
+	if err := h.db.Where("id = ? AND pegawai_id = ?", input.ID, input.Pegawai_ID).First(&keluarga).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Keluarga not found"})
+	}
+	if msg, err := input.validate(h.db); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Keluarga"})
+	} else if msg != "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+	}
+
+	lama := *keluarga
+	keluarga.Nama = input.Nama
+	keluarga.Hubungan = input.Hubungan
+	keluarga.NIK = input.NIK
+	keluarga.Tgl_Lahir = input.Tgl_Lahir
+	keluarga.Jenkel_ID = input.Jenkel_ID
+	keluarga.Tanggungan = input.Tanggungan
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(keluarga).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "keluarga", keluarga.ID, &lama, keluarga)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Keluarga"})
+	}
+
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": "Keluarga updated successfully", "data": keluarga})
+}
+
+func (h *KeluargaHandler) DeleteKeluarga(ctx echo.Context) error {
+	var input KeluargaRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	keluarga := new(Keluarga)
+	if err := h.db.Where("id = ? AND pegawai_id = ?", input.ID, input.Pegawai_ID).First(keluarga).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Keluarga not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(keluarga).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "keluarga", keluarga.ID, keluarga, nil)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to delete Keluarga"})
+	}
+
+	return ctx.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// lookupPegawai lists the lookup tables referenced by Pegawai, with the label
+// used in error messages.
+var lookupPegawai = []struct {
+	table string
+	label string
+	id    func(p *Pegawai) int64
+}{
+	{"jenis_pegawai", "Jenis Pegawai", func(p *Pegawai) int64 { return p.Jenis_Pegawai_ID }},
+	{"status_pegawai", "Status Pegawai", func(p *Pegawai) int64 { return p.Status_Pegawai_ID }},
+	{"pendidikan", "Pendidikan", func(p *Pegawai) int64 { return p.Pendidikan_ID }},
+	{"jenis_kelamin", "Jenis Kelamin", func(p *Pegawai) int64 { return p.Jenkel_ID }},
+	{"agama", "Agama", func(p *Pegawai) int64 { return p.Agama_ID }},
+}
+
+// validasiLookup checks that the lookup values of pegawai exist and are
+// active. When lama is given only values that differ from it are checked, so
+// employees keep inactive values assigned before they were deactivated. It
+// returns a message for a 400 response, or an empty string, and an error
+// when the lookup fails.
+func validasiLookup(db *gorm.DB, pegawai, lama *Pegawai) (string, error) {
+	for _, l := range lookupPegawai {
+		id := l.id(pegawai)
+		if id == 0 || (lama != nil && l.id(lama) == id) {
+			continue
+		}
+		var aktif []bool
+		if err := db.Table(l.table).Where("id = ?", id).Pluck("aktif", &aktif).Error; err != nil {
+			return "", err
+		}
+		if len(aktif) == 0 {
+			return fmt.Sprintf("%s %d not found", l.label, id), nil
+		}
+		if !aktif[0] {
+			return fmt.Sprintf("%s %d is inactive", l.label, id), nil
+		}
+	}
+	return "", nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -8,7 +10,15 @@ import (
 	"strconv"
 	"time"
 
+	"uas/audit"
+	"uas/auth"
+	"uas/gambar"
+	"uas/keamanan"
+	"uas/ratelimit"
+	"uas/storage"
+
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -27,42 +37,184 @@ func initDB() (*gorm.DB, error) {
 		},
 	)
 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: newLogger,
+		Logger:         newLogger,
+		TranslateError: true,
 	})
 	if err != nil {
 		return nil, err
 	}
-	err = db.AutoMigrate(&Pegawai{})
+	err = db.AutoMigrate(&Pegawai{}, &NIPSequence{}, &Keluarga{}, &RiwayatPendidikan{},
+		&JenisCuti{}, &HakCuti{}, &HariLibur{}, &KepalaUnit{}, &Cuti{}, &SaldoCuti{}, &Presensi{}, &Dokumen{}, &UploadSesi{}, &RiwayatStatus{}, &Pengajuan{}, &audit.Log{})
     if err != nil {
         return nil, err
     }
+	if err = db.AutoMigrate(auth.Models()...); err != nil {
+		return nil, err
+	}
+	if err = ensureNIKUniqueIndex(db); err != nil {
+		return nil, err
+	}
+	if err = auth.EnsureRoles(db); err != nil {
+		return nil, err
+	}
+	if err = auth.EnsureAdmin(db); err != nil {
+		return nil, err
+	}
 
 	return db, nil
 }
 
 func main() {
+	// "pegawai-api-2 rekey" re-encrypts sensitive fields instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "rekey" {
+		runRekey(os.Args[2:])
+		return
+	}
+
 	// initialisasi database
 	db, err := initDB()
 	if err != nil {
 		panic(err)
 	}
+	store, err := storage.FromEnv()
+	if err != nil {
+		panic(err)
+	}
+	limiter, err := ratelimit.FromEnv(db)
+	if err != nil {
+		panic(err)
+	}
+	keamananConfig, err := keamanan.FromEnv()
+	if err != nil {
+		panic(err)
+	}
 	// inisialisasi handler
-	pegawaiHandler := NewPegawaiHandler(db)
+	authHandler := auth.NewHandler(db)
+	pegawaiHandler := NewPegawaiHandler(db, store)
+	keluargaHandler := NewKeluargaHandler(db)
+	riwayatPendidikanHandler := NewRiwayatPendidikanHandler(db)
+	cutiMasterHandler := NewCutiMasterHandler(db)
+	cutiHandler := NewCutiHandler(db)
+	presensiHandler := NewPresensiHandler(db)
+	dokumenHandler := NewDokumenHandler(db, store)
+	pengajuanHandler := NewPengajuanHandler(db, store)
+	auditHandler := audit.NewHandler(db)
+
+	go dokumenHandler.HapusUploadKadaluarsa(context.Background(), time.Hour)
 
 	e := echo.New()
+	e.IPExtractor = limiter.IPExtractor()
+	e.Use(middleware.RequestID())
+	e.Use(keamanan.Middleware(keamananConfig))
+	e.Use(limiter.PerIP(ratelimit.GroupIP))
+	// Signed photo URLs are checked by GetGambar itself
+	e.Use(auth.Middleware(db, func(ctx echo.Context) bool {
+		return ctx.Path() == "/pegawai/:id/gambar" && gambar.Signed(ctx.QueryParams())
+	}))
+	e.Use(limiter.PerClient(ratelimit.GroupDefault))
+	// hak akses; routes with :id are also limited to the caller's units
+	baca := auth.Require(db, auth.PegawaiRead)
+	tulis := auth.Require(db, auth.PegawaiWrite)
+	bacaUnit := cekUnit(db, auth.PegawaiRead)
+	tulisUnit := cekUnit(db, auth.PegawaiWrite)
+	semuaTulis := auth.RequireGlobal(db, auth.PegawaiWrite)
+	lookupBaca := auth.Require(db, auth.LookupRead)
+	lookupTulis := auth.RequireGlobal(db, auth.LookupWrite)
+	kelolaUser := auth.RequireGlobal(db, auth.UserManage)
+	bacaAudit := auth.Require(db, auth.AuditRead)
 	// routing
-	e.GET("/pegawai", pegawaiHandler.GetAllPegawai)
-	e.GET("/pegawai/:id", pegawaiHandler.GetPegawaiByID)
-	e.POST("/pegawai", pegawaiHandler.CreatePegawai)
-	e.PUT("/pegawai/:id", pegawaiHandler.UpdatePegawai)
-	e.DELETE("/pegawai/:id", pegawaiHandler.DeletePegawai)
+	e.POST("/auth/login", authHandler.Login, limiter.PerIP(ratelimit.GroupLogin))
+	e.POST("/auth/refresh", authHandler.Refresh)
+	e.POST("/auth/logout", authHandler.Logout)
+	e.PUT("/auth/password", authHandler.UbahPassword)
+	e.GET("/users", authHandler.GetAllUser, kelolaUser)
+	e.POST("/users", authHandler.CreateUser, kelolaUser)
+	e.DELETE("/users/:id", authHandler.DeleteUser, kelolaUser)
+	e.PUT("/users/:id/roles", authHandler.SetUserRoles, kelolaUser)
+	e.PUT("/users/:id/units", authHandler.SetUserUnits, kelolaUser)
+	e.PUT("/users/:id/pegawai", authHandler.SetUserPegawai, kelolaUser)
+	e.GET("/apikeys", authHandler.GetAllApiKey, kelolaUser)
+	e.POST("/apikeys", authHandler.CreateApiKey, kelolaUser)
+	e.POST("/apikeys/:id/rotate", authHandler.RotateApiKey, kelolaUser)
+	e.DELETE("/apikeys/:id", authHandler.DeleteApiKey, kelolaUser)
+	e.GET("/roles", authHandler.GetAllRole, kelolaUser)
+	e.POST("/roles", authHandler.CreateRole, kelolaUser)
+	e.PUT("/roles/:id", authHandler.UpdateRole, kelolaUser)
+	// self-service: every user sees their own linked Pegawai
+	e.GET("/me", pengajuanHandler.GetMe)
+	e.GET("/me/pengajuan", pengajuanHandler.GetAllPengajuanSaya)
+	e.POST("/me/pengajuan", pengajuanHandler.CreatePengajuan)
+	e.DELETE("/me/pengajuan/:pengajuan_id", pengajuanHandler.BatalkanPengajuan)
+	e.GET("/pengajuan", pengajuanHandler.GetAllPengajuan, baca)
+	e.GET("/pengajuan/:pengajuan_id/gambar", pengajuanHandler.GetPengajuanGambar, baca)
+	e.POST("/pengajuan/:pengajuan_id/approve", pengajuanHandler.ApprovePengajuan, tulis)
+	e.POST("/pengajuan/:pengajuan_id/reject", pengajuanHandler.RejectPengajuan, tulis)
+	e.GET("/pegawai", pegawaiHandler.GetAllPegawai, baca, limiter.PerClient(ratelimit.GroupPegawai))
+	e.GET("/pegawai/:id", pegawaiHandler.GetPegawaiByID, baca, bacaUnit)
+	e.GET("/pegawai/nip/:nip", pegawaiHandler.GetPegawaiByNIP, baca)
+	e.GET("/pegawai/duplikat", pegawaiHandler.GetDuplikatPegawai, auth.RequireGlobal(db, auth.PegawaiRead))
+	e.POST("/pegawai/:id/merge", pegawaiHandler.MergePegawai, semuaTulis)
+	e.GET("/audit", auditHandler.GetAllAudit, auth.RequireGlobal(db, auth.AuditRead))
+	e.GET("/pegawai/:id/audit", auditHandler.GetAuditEntitas("pegawai"), bacaAudit, cekUnit(db, auth.AuditRead))
+	e.GET("/pegawai/:id/status", pegawaiHandler.GetRiwayatStatus, baca, bacaUnit)
+	e.POST("/pegawai/:id/status", pegawaiHandler.UbahStatusPegawai, tulis, tulisUnit)
+	e.POST("/pegawai", pegawaiHandler.CreatePegawai, tulis)
+	e.PUT("/pegawai/:id", pegawaiHandler.UpdatePegawai, tulis, tulisUnit)
+	e.DELETE("/pegawai/:id", pegawaiHandler.DeletePegawai, tulis, tulisUnit)
+	e.GET("/pegawai/:id/gambar", pegawaiHandler.GetGambar, kecualiSigned(baca), kecualiSigned(bacaUnit))
+	e.GET("/pegawai/:id/gambar/url", pegawaiHandler.GetGambarURL, baca, bacaUnit)
+	e.PUT("/pegawai/:id/gambar", pegawaiHandler.UpdateGambar, tulis, tulisUnit)
+	e.DELETE("/pegawai/:id/gambar", pegawaiHandler.DeleteGambar, tulis, tulisUnit)
+	e.GET("/pegawai/:id/keluarga", keluargaHandler.GetAllKeluarga, baca, bacaUnit)
+	e.GET("/pegawai/:id/keluarga/:keluarga_id", keluargaHandler.GetKeluargaByID, baca, bacaUnit)
+	e.POST("/pegawai/:id/keluarga", keluargaHandler.CreateKeluarga, tulis, tulisUnit)
+	e.PUT("/pegawai/:id/keluarga/:keluarga_id", keluargaHandler.UpdateKeluarga, tulis, tulisUnit)
+	e.DELETE("/pegawai/:id/keluarga/:keluarga_id", keluargaHandler.DeleteKeluarga, tulis, tulisUnit)
+	e.GET("/pegawai/:id/pendidikan", riwayatPendidikanHandler.GetAllRiwayatPendidikan, baca, bacaUnit)
+	e.GET("/pegawai/:id/pendidikan/:riwayat_id", riwayatPendidikanHandler.GetRiwayatPendidikanByID, baca, bacaUnit)
+	e.POST("/pegawai/:id/pendidikan", riwayatPendidikanHandler.CreateRiwayatPendidikan, tulis, tulisUnit)
+	e.PUT("/pegawai/:id/pendidikan/:riwayat_id", riwayatPendidikanHandler.UpdateRiwayatPendidikan, tulis, tulisUnit)
+	e.DELETE("/pegawai/:id/pendidikan/:riwayat_id", riwayatPendidikanHandler.DeleteRiwayatPendidikan, tulis, tulisUnit)
+	e.GET("/pegawai/:id/dokumen", dokumenHandler.GetAllDokumen, baca, bacaUnit)
+	e.GET("/pegawai/:id/dokumen/:dokumen_id", dokumenHandler.GetDokumenByID, baca, bacaUnit)
+	e.GET("/pegawai/:id/dokumen/:dokumen_id/download", dokumenHandler.DownloadDokumen, baca, bacaUnit)
+	e.POST("/pegawai/:id/dokumen", dokumenHandler.CreateDokumen, tulis, tulisUnit)
+	e.DELETE("/pegawai/:id/dokumen/:dokumen_id", dokumenHandler.DeleteDokumen, tulis, tulisUnit)
+	e.POST("/pegawai/:id/dokumen/upload", dokumenHandler.CreateUpload, tulis, tulisUnit)
+	e.GET("/pegawai/:id/dokumen/upload/:upload_id", dokumenHandler.GetUpload, baca, bacaUnit)
+	e.PATCH("/pegawai/:id/dokumen/upload/:upload_id", dokumenHandler.PatchUpload, tulis, tulisUnit)
+	e.DELETE("/pegawai/:id/dokumen/upload/:upload_id", dokumenHandler.DeleteUpload, tulis, tulisUnit)
+	e.GET("/pegawai/:id/cuti", cutiHandler.GetAllCuti, baca, bacaUnit)
+	e.POST("/pegawai/:id/cuti", cutiHandler.CreateCuti, tulis, tulisUnit)
+	e.GET("/pegawai/:id/cuti/saldo", cutiHandler.GetSaldoCuti, baca, bacaUnit)
+	e.POST("/cuti/:cuti_id/approve", cutiHandler.ApproveCuti, tulis)
+	e.POST("/cuti/:cuti_id/reject", cutiHandler.RejectCuti, tulis)
+	e.POST("/cuti/carry-over", cutiHandler.CarryOver, semuaTulis)
+	e.POST("/pegawai/:id/presensi/checkin", presensiHandler.CheckIn, tulis, tulisUnit)
+	e.POST("/pegawai/:id/presensi/checkout", presensiHandler.CheckOut, tulis, tulisUnit)
+	e.GET("/pegawai/:id/presensi", presensiHandler.GetAllPresensi, baca, bacaUnit)
+	e.GET("/pegawai/:id/presensi/rekap", presensiHandler.GetRekapPegawai, baca, bacaUnit)
+	e.GET("/presensi/rekap", presensiHandler.GetRekapUnit, baca)
+	e.GET("/jeniscuti", cutiMasterHandler.GetAllJenisCuti, lookupBaca)
+	e.POST("/jeniscuti", cutiMasterHandler.CreateJenisCuti, lookupTulis)
+	e.PUT("/jeniscuti/:id", cutiMasterHandler.UpdateJenisCuti, lookupTulis)
+	e.DELETE("/jeniscuti/:id", cutiMasterHandler.DeleteJenisCuti, lookupTulis)
+	e.GET("/hakcuti", cutiMasterHandler.GetAllHakCuti, lookupBaca)
+	e.PUT("/hakcuti", cutiMasterHandler.SetHakCuti, lookupTulis)
+	e.GET("/harilibur", cutiMasterHandler.GetAllHariLibur, lookupBaca)
+	e.POST("/harilibur", cutiMasterHandler.CreateHariLibur, lookupTulis)
+	e.DELETE("/harilibur/:id", cutiMasterHandler.DeleteHariLibur, lookupTulis)
+	e.GET("/kepalaunit", cutiMasterHandler.GetAllKepalaUnit, lookupBaca)
+	e.PUT("/kepalaunit", cutiMasterHandler.SetKepalaUnit, lookupTulis)
 	e.Logger.Fatal(e.Start(":1882"))
 }
 
 type Pegawai struct {
 	ID     		 		int64  `json:"id"`
+	NIP					*string `gorm:"column:nip;size:32;uniqueIndex;<-:create" json:"nip"`
 	Nama_Pegawai   		string `json:"nama_pegawai"`
-	NIK			   		string `json:"nik"`
+	NIK			   		string `gorm:"column:nik;size:128;serializer:enkripsi" json:"nik"`
+	NIK_Index			*string `gorm:"column:nik_index;size:64" json:"-"`
 	Jenis_Pegawai_ID	int64  `json:"jenis_pegawai_id"`
 	Status_Pegawai_ID	int64  `json:"status_pegawai_id"`
 	Unit				string `json:"unit"`
@@ -70,39 +222,51 @@ type Pegawai struct {
 	Pendidikan_ID		int64  `json:"pendidikan_id"`
 	Tgl_Lahir			string `json:"tgl_lahir"`
 	Tpt_Lahir			string `json:"tpt_lahir"`
+	Tgl_Masuk			string `json:"tgl_masuk"`
 	Jenkel_ID			int64  `json:"jenkel_id"`
 	Agama_ID			int64  `json:"agama_id"`
+	Alamat				string `json:"alamat"`
 	Gambar				string `json:"gambar"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (Pegawai) TableName() string {
 	return "pegawai"
 }
 
+// BeforeSave keeps the blind index of the encrypted NIK up to date.
+func (p *Pegawai) BeforeSave(*gorm.DB) error {
+	p.NIK_Index = indeksNIK(p.NIK)
+	return nil
+}
+
 type PegawaiHandler struct {
-	db *gorm.DB
+	db    *gorm.DB
+	store storage.Storage
 }
 
-func NewPegawaiHandler(db *gorm.DB) *PegawaiHandler {
-	return &PegawaiHandler{db: db}
+func NewPegawaiHandler(db *gorm.DB, store storage.Storage) *PegawaiHandler {
+	return &PegawaiHandler{db: db, store: store}
 }
 
 type PegawaiRequest struct {
 	ID  		  		string `param:"id"`
-	Nama_Pegawai   		string `json:"nama_pegawai"`
-	NIK			   		string `json:"nik"`
-	Jenis_Pegawai_ID	int64  `json:"jenis_pegawai_id"`
-	Status_Pegawai_ID	int64  `json:"status_pegawai_id"`
-	Unit				string `json:"unit"`
-	Sub_Unit			string `json:"sub_unit"`
-	Pendidikan_ID		int64  `json:"pendidikan_id"`
-	Tgl_Lahir			string `json:"tgl_lahir"`
-	Tpt_Lahir			string `json:"tpt_lahir"`
-	Jenkel_ID			int64  `json:"jenkel_id"`
-	Agama_ID			int64  `json:"agama_id"`
-	Gambar				string `json:"gambar"`
+	Nama_Pegawai   		string `json:"nama_pegawai" form:"nama_pegawai"`
+	NIK			   		string `json:"nik" form:"nik"`
+	Jenis_Pegawai_ID	int64  `json:"jenis_pegawai_id" form:"jenis_pegawai_id"`
+	Status_Pegawai_ID	int64  `json:"status_pegawai_id" form:"status_pegawai_id"`
+	Unit				string `json:"unit" form:"unit"`
+	Sub_Unit			string `json:"sub_unit" form:"sub_unit"`
+	Pendidikan_ID		int64  `json:"pendidikan_id" form:"pendidikan_id"`
+	Tgl_Lahir			string `json:"tgl_lahir" form:"tgl_lahir"`
+	Tpt_Lahir			string `json:"tpt_lahir" form:"tpt_lahir"`
+	Tgl_Masuk			string `json:"tgl_masuk" form:"tgl_masuk"`
+	Jenkel_ID			int64  `json:"jenkel_id" form:"jenkel_id"`
+	Agama_ID			int64  `json:"agama_id" form:"agama_id"`
+	Alamat				string `json:"alamat" form:"alamat"`
+	Gambar				string `json:"gambar" form:"gambar"`
 }
 
 func (h *PegawaiHandler) GetAllPegawai(ctx echo.Context) error {
@@ -112,10 +276,14 @@ func (h *PegawaiHandler) GetAllPegawai(ctx echo.Context) error {
 	if search != "" {
 		query = query.Where("nama_pegawai LIKE ?", "%"+search+"%")
 	}
+	query, err := scopeUnit(h.db, ctx, auth.PegawaiRead, query)
+	if err != nil {
+		return unitError(ctx, err)
+	}
 	if err := query.Find(&pegawai).Error; err != nil { // SELECT * FROM users
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Pegawai"})
 	}
-	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Users", "data": pegawai, "filter": search})
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Users", "data": pegawai, "filter": search})
 }
 
 func (h *PegawaiHandler) GetPegawaiByID(ctx echo.Context) error {
@@ -130,7 +298,7 @@ func (h *PegawaiHandler) GetPegawaiByID(ctx echo.Context) error {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Pegawai By ID"})
 	}
 
-	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Pegawai By ID : %s", input.ID), "data": pegawai})
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Pegawai By ID : %s", input.ID), "data": pegawai})
 }
 
 func (h *PegawaiHandler) CreatePegawai(ctx echo.Context) error {
@@ -138,6 +306,23 @@ func (h *PegawaiHandler) CreatePegawai(ctx echo.Context) error {
     if err := ctx.Bind(request); err != nil {
         return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
     }
+    if request.NIK == "" {
+        return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "NIK is required"})
+    }
+    // generateNIP reads the year of hire from tgl_masuk
+    if request.Tgl_Masuk != "" {
+        if _, err := time.Parse(tglLayout, request.Tgl_Masuk); err != nil {
+            return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "tgl_masuk must be formatted as YYYY-MM-DD"})
+        }
+    }
+    if ok, err := bolehUnit(h.db, ctx, auth.PegawaiWrite, request.Unit); !ok {
+        return unitError(ctx, err)
+    }
+    if existing, err := findPegawaiByNIK(h.db, request.NIK, 0); err != nil {
+        return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Pegawai"})
+    } else if existing != nil {
+        return nikConflict(ctx, existing)
+    }
 
     pegawai := Pegawai{
         Nama_Pegawai:      request.Nama_Pegawai,
@@ -149,17 +334,62 @@ func (h *PegawaiHandler) CreatePegawai(ctx echo.Context) error {
         Pendidikan_ID:     request.Pendidikan_ID,
         Tgl_Lahir:         request.Tgl_Lahir,
         Tpt_Lahir:         request.Tpt_Lahir,
+        Tgl_Masuk:         request.Tgl_Masuk,
         Jenkel_ID:         request.Jenkel_ID,
         Agama_ID:          request.Agama_ID,
+        Alamat:            request.Alamat,
         CreatedAt:         time.Now(),
         UpdatedAt:         time.Now(),
     }
-
-    if err := h.db.Create(&pegawai).Error; err != nil {
+    if msg, err := validasiLookup(h.db, &pegawai, nil); err != nil {
+        return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Pegawai"})
+    } else if msg != "" {
+        return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+    }
+    if msg, err := statusAwal(h.db, pegawai.Status_Pegawai_ID); err != nil {
         return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Pegawai"})
+    } else if msg != "" {
+        return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+    }
+
+    // The photo is optional; it can be sent as multipart file or data URI
+    staged, err := h.stageGambar(ctx, request)
+    if err == nil {
+        pegawai.Gambar = staged.Name
+    } else if !errors.Is(err, errNoGambar) {
+        return gambarError(ctx, err)
+    }
+
+    // The NIP is generated in the same transaction as the insert so the
+    // reserved sequence number is released again if the insert fails.
+    err = h.db.Transaction(func(tx *gorm.DB) error {
+        nip, err := generateNIP(tx, &pegawai)
+        if err != nil {
+            return err
+        }
+        pegawai.NIP = &nip
+        if err := tx.Create(&pegawai).Error; err != nil {
+            return err
+        }
+        if err := audit.Catat(tx, ctx, "pegawai", pegawai.ID, nil, &pegawai); err != nil {
+            return err
+        }
+        return commitGambar(ctx, staged)
+    })
+    if err != nil {
+        discardGambar(ctx, staged)
+    }
+    if errors.Is(err, gorm.ErrDuplicatedKey) {
+        // Lost a race with a concurrent insert of the same NIK
+        if existing, _ := findPegawaiByNIK(h.db, pegawai.NIK, 0); existing != nil {
+            return nikConflict(ctx, existing)
+        }
+    }
+    if err != nil {
+        return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Pegawai", "error": err.Error()})
     }
 
-    return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Pegawai created successfully", "data": pegawai})
+    return tampil(h.db, ctx, http.StatusCreated, map[string]interface{}{"message": "Pegawai created successfully", "data": pegawai})
 }
 
 func (h *PegawaiHandler) UpdatePegawai(ctx echo.Context) error {
@@ -178,26 +408,88 @@ func (h *PegawaiHandler) UpdatePegawai(ctx echo.Context) error {
     if err := h.db.Where("id = ?", id).First(&pegawai).Error; err != nil {
         return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pegawai not found"})
     }
+    if request.NIK == "" {
+        return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "NIK is required"})
+    }
+    if request.Tgl_Masuk != "" {
+        if _, err := time.Parse(tglLayout, request.Tgl_Masuk); err != nil {
+            return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "tgl_masuk must be formatted as YYYY-MM-DD"})
+        }
+    }
+    // cekUnit checked the current unit; the new one must be allowed too
+    if ok, err := bolehUnit(h.db, ctx, auth.PegawaiWrite, request.Unit); !ok {
+        return unitError(ctx, err)
+    }
+    if existing, err := findPegawaiByNIK(h.db, request.NIK, pegawai.ID); err != nil {
+        return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Pegawai"})
+    } else if existing != nil {
+        return nikConflict(ctx, existing)
+    }
+
+    // Status changes go through POST /pegawai/:id/status
+    if request.Status_Pegawai_ID != 0 && request.Status_Pegawai_ID != pegawai.Status_Pegawai_ID {
+        return ctx.JSON(http.StatusConflict, map[string]string{"message": "Use POST /pegawai/:id/status to change Status Pegawai"})
+    }
 
     // Update Pegawai attributes
+    lama := *pegawai
     pegawai.Nama_Pegawai = request.Nama_Pegawai
     pegawai.NIK = request.NIK
     pegawai.Jenis_Pegawai_ID = request.Jenis_Pegawai_ID
-    pegawai.Status_Pegawai_ID = request.Status_Pegawai_ID
     pegawai.Unit = request.Unit
     pegawai.Sub_Unit = request.Sub_Unit
     pegawai.Pendidikan_ID = request.Pendidikan_ID
     pegawai.Tgl_Lahir = request.Tgl_Lahir
     pegawai.Tpt_Lahir = request.Tpt_Lahir
+    pegawai.Tgl_Masuk = request.Tgl_Masuk
     pegawai.Jenkel_ID = request.Jenkel_ID
     pegawai.Agama_ID = request.Agama_ID
+    pegawai.Alamat = request.Alamat
+    if msg, err := validasiLookup(h.db, pegawai, &lama); err != nil {
+        return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Pegawai"})
+    } else if msg != "" {
+        return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+    }
+    staged, err := h.stageGambar(ctx, request)
+    if err == nil {
+        pegawai.Gambar = staged.Name
+    } else if !errors.Is(err, errNoGambar) {
+        return gambarError(ctx, err)
+    }
 
-    // Save the changes to the database
-    if err := h.db.Save(&pegawai).Error; err != nil {
+    // Save the changes to the database. Pendidikan_ID is derived from the
+    // education history whenever the employee has one.
+    err = h.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Save(&pegawai).Error; err != nil {
+            return err
+        }
+        if err := syncPendidikan(tx, pegawai.ID); err != nil {
+            return err
+        }
+        if err := tx.First(&pegawai, pegawai.ID).Error; err != nil {
+            return err
+        }
+        if err := audit.Catat(tx, ctx, "pegawai", pegawai.ID, &lama, pegawai); err != nil {
+            return err
+        }
+        return commitGambar(ctx, staged)
+    })
+    if err != nil {
+        discardGambar(ctx, staged)
+    }
+    if errors.Is(err, gorm.ErrDuplicatedKey) {
+        if existing, _ := findPegawaiByNIK(h.db, pegawai.NIK, pegawai.ID); existing != nil {
+            return nikConflict(ctx, existing)
+        }
+    }
+    if err != nil {
         return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Pegawai"})
     }
+    if pegawai.Gambar != lama.Gambar {
+        h.hapusGambar(ctx, lama.Gambar)
+    }
 
-    return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Pegawai updated successfully", "data": pegawai})
+    return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": "Pegawai updated successfully", "data": pegawai})
 }
 
 func (h *PegawaiHandler) DeletePegawai(ctx echo.Context) error {
@@ -212,8 +504,23 @@ func (h *PegawaiHandler) DeletePegawai(ctx echo.Context) error {
         return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pegawai not found"})
     }
 
-    // Delete the Pegawai from the database
-    if err := h.db.Delete(&pegawai).Error; err != nil {
+    // Soft delete the Pegawai together with its sub-resources
+    err = h.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("pegawai_id = ?", pegawai.ID).Delete(&Keluarga{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Where("pegawai_id = ?", pegawai.ID).Delete(&RiwayatPendidikan{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Where("pegawai_id = ?", pegawai.ID).Delete(&Dokumen{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Delete(&pegawai).Error; err != nil {
+            return err
+        }
+        return audit.Catat(tx, ctx, "pegawai", pegawai.ID, pegawai, nil)
+    })
+    if err != nil {
         return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to delete Pegawai"})
     }
 
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"uas/auth"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NIP pattern tokens:
+//
+//	{YYYY}    year of hire (4 digits)
+//	{YY}      year of hire (2 digits)
+//	{MM}      month of hire
+//	{UNIT:n}  unit code, upper-cased alphanumerics of Unit, truncated to n characters
+//	{JENIS:n} jenis pegawai code, zero padded to n digits (default 2)
+//	{SEQ:n}   sequence within the rendered prefix, zero padded to n digits (default 4)
+//
+// The pattern is read from NIP_PATTERN.
+const defaultNIPPattern = "{YYYY}{UNIT:4}{JENIS:2}{SEQ:4}"
+
+const tglLayout = "2006-01-02"
+
+var nipToken = regexp.MustCompile(`\{([A-Z]+)(?::(\d+))?\}`)
+
+var nonAlnum = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// NIPSequence holds the last issued sequence number for every rendered NIP
+// prefix, so numbering restarts for each combination of year, unit and jenis.
+type NIPSequence struct {
+	Prefix string `gorm:"primaryKey;size:64"`
+	Last   int64
+}
+
+func (NIPSequence) TableName() string {
+	return "nip_sequence"
+}
+
+// generateNIP renders the configured pattern for pegawai and reserves the next
+// sequence number. It must run inside a transaction: the sequence row is locked
+// until the transaction ends, which serialises concurrent inserts.
+func generateNIP(tx *gorm.DB, pegawai *Pegawai) (string, error) {
+	prefix, seqWidth, err := nipPrefix(getEnv("NIP_PATTERN", defaultNIPPattern), pegawai, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	// Make sure the counter row exists, then lock it for the rest of the transaction.
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&NIPSequence{Prefix: prefix}).Error; err != nil {
+		return "", err
+	}
+	var seq NIPSequence
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("prefix = ?", prefix).First(&seq).Error; err != nil {
+		return "", err
+	}
+	seq.Last++
+	if err := tx.Model(&seq).Where("prefix = ?", prefix).Update("last", seq.Last).Error; err != nil {
+		return "", err
+	}
+
+	return strings.Replace(prefix, "{SEQ}", fmt.Sprintf("%0*d", seqWidth, seq.Last), 1), nil
+}
+
+// nipPrefix renders every token of pattern except {SEQ}, which is left in
+// place, and returns the width of the sequence number. Employees without
+// tgl_masuk are numbered as hired on now.
+func nipPrefix(pattern string, pegawai *Pegawai, now time.Time) (string, int, error) {
+	hired := now
+	if pegawai.Tgl_Masuk != "" {
+		t, err := time.Parse(tglLayout, pegawai.Tgl_Masuk)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid tgl_masuk %q: %w", pegawai.Tgl_Masuk, err)
+		}
+		hired = t
+	}
+
+	seqWidth := 4
+	prefix := nipToken.ReplaceAllStringFunc(pattern, func(token string) string {
+		m := nipToken.FindStringSubmatch(token)
+		width, _ := strconv.Atoi(m[2])
+		switch m[1] {
+		case "YYYY":
+			return fmt.Sprintf("%04d", hired.Year())
+		case "YY":
+			return fmt.Sprintf("%02d", hired.Year()%100)
+		case "MM":
+			return fmt.Sprintf("%02d", int(hired.Month()))
+		case "UNIT":
+			code := strings.ToUpper(nonAlnum.ReplaceAllString(pegawai.Unit, ""))
+			if width > 0 && len(code) > width {
+				code = code[:width]
+			}
+			return code
+		case "JENIS":
+			if width == 0 {
+				width = 2
+			}
+			return fmt.Sprintf("%0*d", width, pegawai.Jenis_Pegawai_ID)
+		case "SEQ":
+			if width > 0 {
+				seqWidth = width
+			}
+			return "{SEQ}"
+		}
+		return token
+	})
+	if !strings.Contains(prefix, "{SEQ}") {
+		return "", 0, errors.New("NIP_PATTERN must contain a {SEQ} token")
+	}
+	return prefix, seqWidth, nil
+}
+
+func (h *PegawaiHandler) GetPegawaiByNIP(ctx echo.Context) error {
+	nip := ctx.Param("nip")
+
+	pegawai := new(Pegawai)
+	if err := h.db.Where("nip = ?", nip).First(&pegawai).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pegawai not found"})
+		}
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Pegawai By NIP"})
+	}
+	if ok, err := bolehUnit(h.db, ctx, auth.PegawaiRead, pegawai.Unit); !ok {
+		return unitError(ctx, err)
+	}
+
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Pegawai By NIP : %s", nip), "data": pegawai})
+}
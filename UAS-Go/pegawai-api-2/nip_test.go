@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNIPPrefix(t *testing.T) {
+	now := time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC)
+	pegawai := &Pegawai{Unit: "Fakultas Teknik", Jenis_Pegawai_ID: 3, Tgl_Masuk: "2019-07-01"}
+	tests := []struct {
+		name     string
+		pattern  string
+		pegawai  *Pegawai
+		prefix   string
+		seqWidth int
+		wantErr  bool
+	}{
+		{"default", defaultNIPPattern, pegawai, "2019FAKU03{SEQ}", 4, false},
+		{"short year and month", "{YY}{MM}-{SEQ:6}", pegawai, "1907-{SEQ}", 6, false},
+		{"whole unit", "{UNIT}{SEQ}", pegawai, "FAKULTASTEKNIK{SEQ}", 4, false},
+		{"wide jenis", "{JENIS:4}{SEQ:2}", pegawai, "0003{SEQ}", 2, false},
+		{"unknown token kept", "{FOO}{SEQ}", pegawai, "{FOO}{SEQ}", 4, false},
+		{"hired now without tgl_masuk", "{YYYY}{MM}{SEQ}", &Pegawai{}, "202403{SEQ}", 4, false},
+		{"missing seq", "{YYYY}{UNIT:4}", pegawai, "", 0, true},
+		{"malformed tgl_masuk", defaultNIPPattern, &Pegawai{Tgl_Masuk: "01-07-2019"}, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, seqWidth, err := nipPrefix(tt.pattern, tt.pegawai, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if prefix != tt.prefix || seqWidth != tt.seqWidth {
+				t.Errorf("nipPrefix() = %q, %d; want %q, %d", prefix, seqWidth, tt.prefix, tt.seqWidth)
+			}
+		})
+	}
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"uas/audit"
+	"uas/auth"
+	"uas/gambar"
+	"uas/storage"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	PengajuanProfil     = "profil"
+	PengajuanGambar     = "gambar"
+	PengajuanPendidikan = "pendidikan"
+
+	StatusPengajuanDiajukan   = "diajukan"
+	StatusPengajuanDisetujui  = "disetujui"
+	StatusPengajuanDitolak    = "ditolak"
+	StatusPengajuanDibatalkan = "dibatalkan"
+)
+
+// Pengajuan is a change an employee proposes to their own data through the
+// self-service endpoints. It is applied to the Pegawai only when HR approves
+// it. Depending on Jenis it carries Profil, Pendidikan or the file name of a
+// new photo in Gambar.
+type Pengajuan struct {
+	ID           int64             `json:"id"`
+	Pegawai_ID   int64             `gorm:"index" json:"pegawai_id"`
+	User_ID      int64             `json:"user_id"`
+	Jenis        string            `gorm:"size:20" json:"jenis"`
+	Profil       *UsulanProfil     `gorm:"serializer:json;type:text" json:"profil,omitempty"`
+	Pendidikan   *UsulanPendidikan `gorm:"serializer:json;type:text" json:"pendidikan,omitempty"`
+	Gambar       string            `json:"gambar,omitempty"`
+	Alasan       string            `json:"alasan"`
+	Status       string            `gorm:"size:20;index" json:"status"`
+	Diputus_Oleh *int64            `json:"diputus_oleh"` // user who approved or rejected the request
+	Catatan      string            `json:"catatan"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+func (Pengajuan) TableName() string {
+	return "pengajuan_perubahan"
+}
+
+// UsulanProfil are the personal fields an employee may correct. Fields left
+// out stay as they are. Organisational fields are HR's alone, and the NIK is
+// corrected by HR against the identity card.
+type UsulanProfil struct {
+	Nama_Pegawai *string `json:"nama_pegawai,omitempty"`
+	Tgl_Lahir    *string `json:"tgl_lahir,omitempty"`
+	Tpt_Lahir    *string `json:"tpt_lahir,omitempty"`
+	Jenkel_ID    *int64  `json:"jenkel_id,omitempty"`
+	Agama_ID     *int64  `json:"agama_id,omitempty"`
+	Alamat       *string `json:"alamat,omitempty"`
+}
+
+func (u *UsulanProfil) kosong() bool {
+	return *u == UsulanProfil{}
+}
+
+// terapkan sets the proposed fields on pegawai.
+func (u *UsulanProfil) terapkan(pegawai *Pegawai) {
+	if u.Nama_Pegawai != nil {
+		pegawai.Nama_Pegawai = *u.Nama_Pegawai
+	}
+	if u.Tgl_Lahir != nil {
+		pegawai.Tgl_Lahir = *u.Tgl_Lahir
+	}
+	if u.Tpt_Lahir != nil {
+		pegawai.Tpt_Lahir = *u.Tpt_Lahir
+	}
+	if u.Jenkel_ID != nil {
+		pegawai.Jenkel_ID = *u.Jenkel_ID
+	}
+	if u.Agama_ID != nil {
+		pegawai.Agama_ID = *u.Agama_ID
+	}
+	if u.Alamat != nil {
+		pegawai.Alamat = *u.Alamat
+	}
+}
+
+// validasi returns a message for a 400 response when the proposal can't be
+// applied to pegawai, or an empty string, and an error when a lookup fails.
+func (u *UsulanProfil) validasi(db *gorm.DB, pegawai *Pegawai) (string, error) {
+	if u.kosong() {
+		return "profil must change at least one field", nil
+	}
+	if u.Nama_Pegawai != nil && strings.TrimSpace(*u.Nama_Pegawai) == "" {
+		return "nama_pegawai must not be empty", nil
+	}
+	if u.Tgl_Lahir != nil {
+		if _, err := time.Parse(tglLayout, *u.Tgl_Lahir); err != nil {
+			return "tgl_lahir must be a date as YYYY-MM-DD", nil
+		}
+	}
+	baru := *pegawai
+	u.terapkan(&baru)
+	return validasiLookup(db, &baru, pegawai)
+}
+
+// UsulanPendidikan is a degree or certificate to add to the education
+// history.
+type UsulanPendidikan struct {
+	Pendidikan_ID int64  `json:"pendidikan_id"`
+	Institusi     string `json:"institusi"`
+	Jurusan       string `json:"jurusan"`
+	Tahun_Lulus   int    `json:"tahun_lulus"`
+	Ijazah        string `json:"ijazah"`
+}
+
+func (u *UsulanPendidikan) validasi(db *gorm.DB) (string, error) {
+	request := RiwayatPendidikanRequest{
+		Pendidikan_ID: u.Pendidikan_ID,
+		Institusi:     u.Institusi,
+		Jurusan:       u.Jurusan,
+		Tahun_Lulus:   u.Tahun_Lulus,
+		Ijazah:        u.Ijazah,
+	}
+	return request.validate(db)
+}
+
+// PengajuanHandler serves the self-service endpoints under /me and the
+// review of change requests by HR. It shares the photo handling of
+// PegawaiHandler.
+type PengajuanHandler struct {
+	*PegawaiHandler
+}
+
+func NewPengajuanHandler(db *gorm.DB, store storage.Storage) *PengajuanHandler {
+	return &PengajuanHandler{NewPegawaiHandler(db, store)}
+}
+
+type PengajuanRequest struct {
+	ID         string            `param:"pengajuan_id"`
+	Jenis      string            `json:"jenis" form:"jenis"`
+	Profil     *UsulanProfil     `json:"profil"`
+	Pendidikan *UsulanPendidikan `json:"pendidikan"`
+	Gambar     string            `json:"gambar" form:"gambar"`
+	Alasan     string            `json:"alasan" form:"alasan"`
+	Catatan    string            `json:"catatan"`
+}
+
+var (
+	errBukanUser    = errors.New("self-service needs a user login")
+	errTanpaPegawai = errors.New("no Pegawai is linked to this account")
+)
+
+// pegawaiSaya returns the employee record linked to the logged in user.
+func pegawaiSaya(db *gorm.DB, ctx echo.Context) (*Pegawai, error) {
+	claims := auth.FromContext(ctx)
+	if claims == nil {
+		return nil, errBukanUser
+	}
+	var ids []*int64
+	if err := db.Model(&auth.User{}).Where("id = ?", claims.UserID()).Pluck("pegawai_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 || ids[0] == nil {
+		return nil, errTanpaPegawai
+	}
+	pegawai, err := findPegawai(db, strconv.FormatInt(*ids[0], 10))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errTanpaPegawai
+	}
+	return pegawai, err
+}
+
+// sayaError writes the response for an error returned by pegawaiSaya.
+func sayaError(ctx echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errBukanUser):
+		return ctx.JSON(http.StatusForbidden, map[string]string{"message": err.Error()})
+	case errors.Is(err, errTanpaPegawai):
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Pegawai"})
+}
+
+// ekspansiSaya are the sub-resources GET /me can include with ?expand=.
+var ekspansiSaya = map[string]func(db *gorm.DB, pegawaiID int64) (interface{}, error){
+	"keluarga": func(db *gorm.DB, pegawaiID int64) (interface{}, error) {
+		keluarga := make([]*Keluarga, 0)
+		err := db.Where("pegawai_id = ?", pegawaiID).Find(&keluarga).Error
+		return keluarga, err
+	},
+	"pendidikan": func(db *gorm.DB, pegawaiID int64) (interface{}, error) {
+		riwayat := make([]*RiwayatPendidikan, 0)
+		err := db.Where("pegawai_id = ?", pegawaiID).Order("tahun_lulus").Find(&riwayat).Error
+		return riwayat, err
+	},
+	"dokumen": func(db *gorm.DB, pegawaiID int64) (interface{}, error) {
+		dokumen := make([]*Dokumen, 0)
+		err := db.Where("pegawai_id = ?", pegawaiID).Find(&dokumen).Error
+		return dokumen, err
+	},
+	"status": func(db *gorm.DB, pegawaiID int64) (interface{}, error) {
+		riwayat := make([]*RiwayatStatus, 0)
+		err := db.Where("pegawai_id = ?", pegawaiID).Order("id").Find(&riwayat).Error
+		return riwayat, err
+	},
+	"cuti": func(db *gorm.DB, pegawaiID int64) (interface{}, error) {
+		cuti := make([]*Cuti, 0)
+		err := db.Where("pegawai_id = ?", pegawaiID).Order("tgl_mulai").Find(&cuti).Error
+		return cuti, err
+	},
+	"pengajuan": func(db *gorm.DB, pegawaiID int64) (interface{}, error) {
+		pengajuan := make([]*Pengajuan, 0)
+		err := db.Where("pegawai_id = ?", pegawaiID).Order("id DESC").Find(&pengajuan).Error
+		return pengajuan, err
+	},
+}
+
+// GetMe returns the employee record of the logged in user, with the
+// sub-resources named in ?expand= (comma separated) and a signed URL of the
+// photo. Employees see their own data unmasked.
+func (h *PengajuanHandler) GetMe(ctx echo.Context) error {
+	pegawai, err := pegawaiSaya(h.db, ctx)
+	if err != nil {
+		return sayaError(ctx, err)
+	}
+
+	data := map[string]interface{}{"pegawai": pegawai}
+	for _, nama := range strings.Split(ctx.QueryParam("expand"), ",") {
+		if nama = strings.TrimSpace(nama); nama == "" {
+			continue
+		}
+		load, ok := ekspansiSaya[nama]
+		if !ok {
+			valid := make([]string, 0, len(ekspansiSaya))
+			for nama := range ekspansiSaya {
+				valid = append(valid, nama)
+			}
+			sort.Strings(valid)
+			return ctx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "Unknown expand " + nama, "data": valid})
+		}
+		if data[nama], err = load(h.db, pegawai.ID); err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get " + nama})
+		}
+	}
+	if pegawai.Gambar != "" {
+		id := strconv.FormatInt(pegawai.ID, 10)
+		data["gambar_url"] = "/pegawai/" + id + "/gambar?" + gambar.SignedQuery(id, "", time.Now().Add(15*time.Minute))
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get Me", "data": data})
+}
+
+// GetAllPengajuanSaya lists the change requests of the logged in user's
+// employee record, newest first.
+func (h *PengajuanHandler) GetAllPengajuanSaya(ctx echo.Context) error {
+	pegawai, err := pegawaiSaya(h.db, ctx)
+	if err != nil {
+		return sayaError(ctx, err)
+	}
+	pengajuan := make([]*Pengajuan, 0)
+	query := h.db.Where("pegawai_id = ?", pegawai.ID).Order("id DESC")
+	if status := ctx.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Find(&pengajuan).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Pengajuan"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Pengajuan", "data": pengajuan})
+}
+
+// CreatePengajuan proposes a change to the logged in user's own data. A
+// photo is sent like for PUT /pegawai/:id/gambar. There is at most one
+// pending profile and one pending photo request per employee.
+func (h *PengajuanHandler) CreatePengajuan(ctx echo.Context) error {
+	var input PengajuanRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := pegawaiSaya(h.db, ctx)
+	if err != nil {
+		return sayaError(ctx, err)
+	}
+
+	pengajuan := Pengajuan{
+		Pegawai_ID: pegawai.ID,
+		User_ID:    auth.FromContext(ctx).UserID(),
+		Jenis:      input.Jenis,
+		Alasan:     input.Alasan,
+		Status:     StatusPengajuanDiajukan,
+	}
+	switch input.Jenis {
+	case PengajuanProfil:
+		if input.Profil == nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "profil is required"})
+		}
+		if msg, err := input.Profil.validasi(h.db, pegawai); err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Pengajuan"})
+		} else if msg != "" {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+		}
+		pengajuan.Profil = input.Profil
+	case PengajuanPendidikan:
+		if input.Pendidikan == nil {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "pendidikan is required"})
+		}
+		if msg, err := input.Pendidikan.validasi(h.db); err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Pengajuan"})
+		} else if msg != "" {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+		}
+		pengajuan.Pendidikan = input.Pendidikan
+	case PengajuanGambar:
+	default:
+		return ctx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "Invalid jenis", "data": []string{PengajuanProfil, PengajuanGambar, PengajuanPendidikan}})
+	}
+
+	if input.Jenis != PengajuanPendidikan {
+		var pending int64
+		err := h.db.Model(&Pengajuan{}).
+			Where("pegawai_id = ? AND jenis = ? AND status = ?", pegawai.ID, input.Jenis, StatusPengajuanDiajukan).
+			Count(&pending).Error
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Pengajuan"})
+		}
+		if pending > 0 {
+			return ctx.JSON(http.StatusConflict, map[string]string{"message": "A Pengajuan " + input.Jenis + " is already pending"})
+		}
+	}
+
+	// The photo is stored with the request, which references it until it is
+	// decided.
+	var staged *gambar.Staged
+	if input.Jenis == PengajuanGambar {
+		staged, err = h.stageGambar(ctx, &PegawaiRequest{Gambar: input.Gambar})
+		if errors.Is(err, errNoGambar) {
+			return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Gambar is required"})
+		}
+		if err != nil {
+			return gambarError(ctx, err)
+		}
+		pengajuan.Gambar = staged.Name
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&pengajuan).Error; err != nil {
+			return err
+		}
+		return commitGambar(ctx, staged)
+	})
+	if err != nil {
+		discardGambar(ctx, staged)
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Pengajuan"})
+	}
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Pengajuan created successfully", "data": pengajuan})
+}
+
+// BatalkanPengajuan withdraws a pending request of the logged in user.
+func (h *PengajuanHandler) BatalkanPengajuan(ctx echo.Context) error {
+	pegawai, err := pegawaiSaya(h.db, ctx)
+	if err != nil {
+		return sayaError(ctx, err)
+	}
+
+	var count int64
+	err = h.db.Model(&Pengajuan{}).Where("id = ? AND pegawai_id = ?", ctx.Param("pengajuan_id"), pegawai.ID).Count(&count).Error
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to cancel Pengajuan"})
+	}
+	if count == 0 {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pengajuan not found"})
+	}
+
+	pengajuan, conflict, err := h.putuskan(ctx.Param("pengajuan_id"), func(tx *gorm.DB, p *Pengajuan) (string, error) {
+		p.Status = StatusPengajuanDibatalkan
+		return "", nil
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to cancel Pengajuan"})
+	}
+	if conflict != "" {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": conflict})
+	}
+	h.hapusGambar(ctx, pengajuan.Gambar)
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Pengajuan " + pengajuan.Status, "data": pengajuan})
+}
+
+// GetAllPengajuan lists the change requests of the employees the caller may
+// read, filtered by ?status= and ?jenis=.
+func (h *PengajuanHandler) GetAllPengajuan(ctx echo.Context) error {
+	pegawai, err := scopeUnit(h.db, ctx, auth.PegawaiRead, h.db.Model(&Pegawai{}).Select("id"))
+	if err != nil {
+		return unitError(ctx, err)
+	}
+	pengajuan := make([]*Pengajuan, 0)
+	query := h.db.Where("pegawai_id IN (?)", pegawai).Order("id")
+	if status := ctx.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if jenis := ctx.QueryParam("jenis"); jenis != "" {
+		query = query.Where("jenis = ?", jenis)
+	}
+	if err := query.Find(&pengajuan).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Pengajuan"})
+	}
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Pengajuan", "data": pengajuan})
+}
+
+// findPengajuan returns the request :pengajuan_id and its employee, checking
+// that the caller has izin for the employee's unit. It writes the response
+// itself when it fails.
+func (h *PengajuanHandler) findPengajuan(ctx echo.Context, izin string) (*Pengajuan, *Pegawai, error) {
+	pengajuan := new(Pengajuan)
+	if err := h.db.Where("id = ?", ctx.Param("pengajuan_id")).First(pengajuan).Error; err != nil {
+		return nil, nil, ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pengajuan not found"})
+	}
+	pegawai, err := findPegawai(h.db, strconv.FormatInt(pengajuan.Pegawai_ID, 10))
+	if err != nil {
+		return nil, nil, pegawaiLookupError(ctx, err)
+	}
+	if ok, err := bolehUnit(h.db, ctx, izin, pegawai.Unit); !ok {
+		return nil, nil, unitError(ctx, err)
+	}
+	return pengajuan, pegawai, nil
+}
+
+// GetPengajuanGambar streams the photo proposed by a request, for review.
+func (h *PengajuanHandler) GetPengajuanGambar(ctx echo.Context) error {
+	pengajuan, _, err := h.findPengajuan(ctx, auth.PegawaiRead)
+	if pengajuan == nil {
+		return err
+	}
+	if pengajuan.Gambar == "" {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pengajuan has no Gambar"})
+	}
+
+	err = gambar.Serve(ctx.Response(), ctx.Request(), h.store, pengajuan.Gambar, ctx.QueryParam("size"))
+	if errors.Is(err, gambar.ErrSize) {
+		return ctx.JSON(http.StatusBadRequest, map[string]interface{}{"message": "Unknown size", "data": gambar.SizeNames()})
+	}
+	if errors.Is(err, storage.ErrNotExist) {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Gambar file not found"})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Gambar"})
+	}
+	return nil
+}
+
+func (h *PengajuanHandler) ApprovePengajuan(ctx echo.Context) error {
+	return h.putuskanPengajuan(ctx, StatusPengajuanDisetujui)
+}
+
+func (h *PengajuanHandler) RejectPengajuan(ctx echo.Context) error {
+	return h.putuskanPengajuan(ctx, StatusPengajuanDitolak)
+}
+
+// putuskanPengajuan approves or rejects a pending request. Callers need
+// write access to the employee's unit and can't decide their own requests.
+// Approved changes are applied to the Pegawai in the same transaction.
+func (h *PengajuanHandler) putuskanPengajuan(ctx echo.Context, status string) error {
+	var input PengajuanRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pengajuan, _, err := h.findPengajuan(ctx, auth.PegawaiWrite)
+	if pengajuan == nil {
+		return err
+	}
+	var pemutus *int64
+	if claims := auth.FromContext(ctx); claims != nil {
+		id := claims.UserID()
+		if id == pengajuan.User_ID {
+			return ctx.JSON(http.StatusForbidden, map[string]string{"message": "You can't decide your own Pengajuan"})
+		}
+		pemutus = &id
+	}
+
+	var gambarLama string
+	pengajuan, conflict, err := h.putuskan(input.ID, func(tx *gorm.DB, p *Pengajuan) (string, error) {
+		p.Status = status
+		p.Diputus_Oleh = pemutus
+		p.Catatan = input.Catatan
+		if status != StatusPengajuanDisetujui {
+			return "", nil
+		}
+		return h.terapkan(tx, ctx, p, &gambarLama)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Pengajuan"})
+	}
+	if conflict != "" {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": conflict})
+	}
+	if status == StatusPengajuanDisetujui {
+		h.hapusGambar(ctx, gambarLama)
+	} else {
+		h.hapusGambar(ctx, pengajuan.Gambar)
+	}
+
+	return tampil(h.db, ctx, http.StatusOK, map[string]interface{}{"message": "Pengajuan " + status, "data": pengajuan})
+}
+
+// putuskan locks the pending request id and saves it after ubah has set its
+// new status. ubah returns a message for a 409 response to leave the request
+// unchanged; requests that are no longer pending are a conflict as well.
+func (h *PengajuanHandler) putuskan(id string, ubah func(tx *gorm.DB, p *Pengajuan) (string, error)) (*Pengajuan, string, error) {
+	pengajuan := new(Pengajuan)
+	var conflict string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(pengajuan).Error; err != nil {
+			return err
+		}
+		if pengajuan.Status != StatusPengajuanDiajukan {
+			conflict = "Pengajuan has already been " + pengajuan.Status
+			return nil
+		}
+		var err error
+		if conflict, err = ubah(tx, pengajuan); err != nil || conflict != "" {
+			return err
+		}
+		return tx.Save(pengajuan).Error
+	})
+	return pengajuan, conflict, err
+}
+
+// terapkan applies an approved request to its Pegawai. For photos the
+// replaced file name is stored in gambarLama, to be removed after the commit.
+func (h *PengajuanHandler) terapkan(tx *gorm.DB, ctx echo.Context, p *Pengajuan, gambarLama *string) (string, error) {
+	pegawai := new(Pegawai)
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(pegawai, p.Pegawai_ID).Error; err != nil {
+		return "", err
+	}
+	lama := *pegawai
+
+	switch p.Jenis {
+	case PengajuanProfil:
+		// Lookup values may have been deactivated since the request was made
+		if msg, err := p.Profil.validasi(tx, pegawai); msg != "" || err != nil {
+			return msg, err
+		}
+		p.Profil.terapkan(pegawai)
+		if err := tx.Save(pegawai).Error; err != nil {
+			return "", err
+		}
+	case PengajuanGambar:
+		if err := tx.Model(pegawai).Update("gambar", p.Gambar).Error; err != nil {
+			return "", err
+		}
+		*gambarLama = lama.Gambar
+	case PengajuanPendidikan:
+		if msg, err := p.Pendidikan.validasi(tx); msg != "" || err != nil {
+			return msg, err
+		}
+		riwayat := RiwayatPendidikan{
+			Pegawai_ID:    pegawai.ID,
+			Pendidikan_ID: p.Pendidikan.Pendidikan_ID,
+			Institusi:     p.Pendidikan.Institusi,
+			Jurusan:       p.Pendidikan.Jurusan,
+			Tahun_Lulus:   p.Pendidikan.Tahun_Lulus,
+			Ijazah:        p.Pendidikan.Ijazah,
+		}
+		if err := tx.Create(&riwayat).Error; err != nil {
+			return "", err
+		}
+		if err := syncPendidikan(tx, pegawai.ID); err != nil {
+			return "", err
+		}
+		if err := tx.First(pegawai, pegawai.ID).Error; err != nil {
+			return "", err
+		}
+	}
+	return "", audit.Catat(tx, ctx, "pegawai", pegawai.ID, &lama, pegawai)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"uas/auth"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Work schedule used for the late and early-leave flags. Times are "HH:MM"
+// in server local time and are read from JAM_MASUK, JAM_PULANG and
+// TOLERANSI_TERLAMBAT (grace period in minutes).
+const (
+	defaultJamMasuk           = "08:00"
+	defaultJamPulang          = "16:00"
+	defaultToleransiTerlambat = "0"
+)
+
+// Presensi is the attendance record of one employee for one day.
+type Presensi struct {
+	ID            int64      `json:"id"`
+	Pegawai_ID    int64      `gorm:"uniqueIndex:idx_presensi" json:"pegawai_id"`
+	Tanggal       string     `gorm:"size:10;uniqueIndex:idx_presensi" json:"tanggal"`
+	Jam_Masuk     *time.Time `json:"jam_masuk"`
+	Sumber_Masuk  string     `json:"sumber_masuk"`
+	Jam_Pulang    *time.Time `json:"jam_pulang"`
+	Sumber_Pulang string     `json:"sumber_pulang"`
+	Terlambat     bool       `json:"terlambat"`
+	Pulang_Cepat  bool       `json:"pulang_cepat"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (Presensi) TableName() string {
+	return "presensi"
+}
+
+// RekapPresensi summarises one employee's attendance over a month. Only
+// working days up to today are counted.
+type RekapPresensi struct {
+	Pegawai_ID   int64  `json:"pegawai_id"`
+	Nama_Pegawai string `json:"nama_pegawai"`
+	Unit         string `json:"unit"`
+	Hari_Kerja   int    `json:"hari_kerja"`
+	Hadir        int    `json:"hadir"`
+	Terlambat    int    `json:"terlambat"`
+	Pulang_Cepat int    `json:"pulang_cepat"`
+	Cuti         int    `json:"cuti"`
+	Tidak_Hadir  int    `json:"tidak_hadir"`
+}
+
+type PresensiHandler struct {
+	db *gorm.DB
+}
+
+func NewPresensiHandler(db *gorm.DB) *PresensiHandler {
+	return &PresensiHandler{db: db}
+}
+
+type PresensiRequest struct {
+	Pegawai_ID string `param:"id"`
+	Sumber     string `json:"sumber"`
+}
+
+// jadwal returns the configured start and end of the working day on date.
+func jadwal(date time.Time) (masuk, pulang time.Time, err error) {
+	toleransi, err := strconv.Atoi(getEnv("TOLERANSI_TERLAMBAT", defaultToleransiTerlambat))
+	if err != nil {
+		return masuk, pulang, fmt.Errorf("invalid TOLERANSI_TERLAMBAT: %w", err)
+	}
+	at := func(clock string) (time.Time, error) {
+		t, err := time.Parse("15:04", clock)
+		if err != nil {
+			return t, err
+		}
+		return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, date.Location()), nil
+	}
+	if masuk, err = at(getEnv("JAM_MASUK", defaultJamMasuk)); err != nil {
+		return masuk, pulang, fmt.Errorf("invalid JAM_MASUK: %w", err)
+	}
+	if pulang, err = at(getEnv("JAM_PULANG", defaultJamPulang)); err != nil {
+		return masuk, pulang, fmt.Errorf("invalid JAM_PULANG: %w", err)
+	}
+	return masuk.Add(time.Duration(toleransi) * time.Minute), pulang, nil
+}
+
+// parseBulan reads the ?bulan=YYYY-MM query parameter, defaulting to the
+// current month, and returns its first and last day.
+func parseBulan(ctx echo.Context) (start, end time.Time, err error) {
+	now := time.Now()
+	start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	if bulan := ctx.QueryParam("bulan"); bulan != "" {
+		if start, err = time.ParseInLocation("2006-01", bulan, time.Local); err != nil {
+			return start, end, err
+		}
+	}
+	return start, start.AddDate(0, 1, -1), nil
+}
+
+func (h *PresensiHandler) CheckIn(ctx echo.Context) error {
+	var input PresensiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := findPegawai(h.db, input.Pegawai_ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	now := time.Now()
+	masuk, _, err := jadwal(now)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+
+	presensi := Presensi{
+		Pegawai_ID:   pegawai.ID,
+		Tanggal:      now.Format(tglLayout),
+		Jam_Masuk:    &now,
+		Sumber_Masuk: input.Sumber,
+		Terlambat:    now.After(masuk),
+	}
+	existing := new(Presensi)
+	err = h.db.Where("pegawai_id = ? AND tanggal = ?", pegawai.ID, presensi.Tanggal).First(existing).Error
+	if err == nil {
+		return ctx.JSON(http.StatusConflict, map[string]interface{}{"message": "Already checked in today", "data": existing})
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to check in"})
+	}
+	// The unique index on (pegawai_id, tanggal) rejects a concurrent second check-in.
+	err = h.db.Create(&presensi).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": "Already checked in today"})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to check in"})
+	}
+
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Succesfully checked in", "data": presensi})
+}
+
+func (h *PresensiHandler) CheckOut(ctx echo.Context) error {
+	var input PresensiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := findPegawai(h.db, input.Pegawai_ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	now := time.Now()
+	_, pulang, err := jadwal(now)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+
+	presensi := new(Presensi)
+	err = h.db.Where("pegawai_id = ? AND tanggal = ?", pegawai.ID, now.Format(tglLayout)).First(presensi).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": "Not checked in today"})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to check out"})
+	}
+
+	// Checking out again overwrites the earlier check-out time.
+	presensi.Jam_Pulang = &now
+	presensi.Sumber_Pulang = input.Sumber
+	presensi.Pulang_Cepat = now.Before(pulang)
+	if err := h.db.Save(presensi).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to check out"})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully checked out", "data": presensi})
+}
+
+func (h *PresensiHandler) GetAllPresensi(ctx echo.Context) error {
+	pegawai, err := findPegawai(h.db, ctx.Param("id"))
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	start, end, err := parseBulan(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "bulan must be formatted as YYYY-MM"})
+	}
+
+	presensi := make([]*Presensi, 0)
+	err = h.db.Where("pegawai_id = ? AND tanggal BETWEEN ? AND ?", pegawai.ID, start.Format(tglLayout), end.Format(tglLayout)).
+		Order("tanggal").Find(&presensi).Error
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Presensi"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Presensi", "data": presensi})
+}
+
+// GetRekapPegawai returns the monthly recap of one employee.
+func (h *PresensiHandler) GetRekapPegawai(ctx echo.Context) error {
+	pegawai, err := findPegawai(h.db, ctx.Param("id"))
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	return h.rekap(ctx, []*Pegawai{pegawai})
+}
+
+// GetRekapUnit returns the monthly recap of every employee in ?unit=.
+func (h *PresensiHandler) GetRekapUnit(ctx echo.Context) error {
+	unit := ctx.QueryParam("unit")
+	if unit == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "unit is required"})
+	}
+	if ok, err := bolehUnit(h.db, ctx, auth.PegawaiRead, unit); !ok {
+		return unitError(ctx, err)
+	}
+	pegawai := make([]*Pegawai, 0)
+	if err := h.db.Where("unit = ?", unit).Order("nama_pegawai").Find(&pegawai).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Rekap Presensi"})
+	}
+	return h.rekap(ctx, pegawai)
+}
+
+// rekap builds the recap for pegawai and writes it as JSON, or as CSV when
+// ?format=csv is given.
+func (h *PresensiHandler) rekap(ctx echo.Context, pegawai []*Pegawai) error {
+	start, end, err := parseBulan(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "bulan must be formatted as YYYY-MM"})
+	}
+	if today := time.Now(); end.After(today) {
+		end = today
+	}
+	days, err := hariKerja(h.db, start, end)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Rekap Presensi"})
+	}
+
+	rekap := make([]*RekapPresensi, 0, len(pegawai))
+	for _, p := range pegawai {
+		r, err := rekapPegawai(h.db, p, days)
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Rekap Presensi"})
+		}
+		rekap = append(rekap, r)
+	}
+
+	bulan := start.Format("2006-01")
+	if ctx.QueryParam("format") != "csv" {
+		return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get Rekap Presensi", "data": rekap, "bulan": bulan})
+	}
+
+	ctx.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="rekap-presensi-%s.csv"`, bulan))
+	ctx.Response().WriteHeader(http.StatusOK)
+	w := csv.NewWriter(ctx.Response())
+	w.Write([]string{"pegawai_id", "nama_pegawai", "unit", "hari_kerja", "hadir", "terlambat", "pulang_cepat", "cuti", "tidak_hadir"})
+	for _, r := range rekap {
+		w.Write([]string{
+			strconv.FormatInt(r.Pegawai_ID, 10), r.Nama_Pegawai, r.Unit,
+			strconv.Itoa(r.Hari_Kerja), strconv.Itoa(r.Hadir), strconv.Itoa(r.Terlambat),
+			strconv.Itoa(r.Pulang_Cepat), strconv.Itoa(r.Cuti), strconv.Itoa(r.Tidak_Hadir),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// rekapPegawai classifies every working day as present, on approved leave or
+// absent for one employee.
+func rekapPegawai(db *gorm.DB, pegawai *Pegawai, days []time.Time) (*RekapPresensi, error) {
+	r := &RekapPresensi{Pegawai_ID: pegawai.ID, Nama_Pegawai: pegawai.Nama_Pegawai, Unit: pegawai.Unit, Hari_Kerja: len(days)}
+	if len(days) == 0 {
+		return r, nil
+	}
+	from, to := days[0].Format(tglLayout), days[len(days)-1].Format(tglLayout)
+
+	presensi := make([]*Presensi, 0)
+	if err := db.Where("pegawai_id = ? AND tanggal BETWEEN ? AND ?", pegawai.ID, from, to).Find(&presensi).Error; err != nil {
+		return nil, err
+	}
+	hadir := make(map[string]*Presensi, len(presensi))
+	for _, p := range presensi {
+		hadir[p.Tanggal] = p
+	}
+
+	cuti := make([]*Cuti, 0)
+	err := db.Where("pegawai_id = ? AND status = ? AND tgl_mulai <= ? AND tgl_selesai >= ?", pegawai.ID, StatusCutiDisetujui, to, from).
+		Find(&cuti).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range days {
+		tanggal := d.Format(tglLayout)
+		if p, ok := hadir[tanggal]; ok {
+			r.Hadir++
+			if p.Terlambat {
+				r.Terlambat++
+			}
+			if p.Pulang_Cepat {
+				r.Pulang_Cepat++
+			}
+			continue
+		}
+		onLeave := false
+		for _, c := range cuti {
+			if c.Tgl_Mulai <= tanggal && tanggal <= c.Tgl_Selesai {
+				onLeave = true
+				break
+			}
+		}
+		if onLeave {
+			r.Cuti++
+		} else {
+			r.Tidak_Hadir++
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"uas/enkripsi"
+
+	"gorm.io/gorm"
+)
+
+// indeksNIK returns the blind index of nik, nil for an empty NIK.
+func indeksNIK(nik string) *string {
+	index := enkripsi.BlindIndex("nik", nik)
+	if index == "" {
+		return nil
+	}
+	return &index
+}
+
+// simpanNIK rewrites the NIK and its blind index of the employees matched by
+// query, including soft-deleted ones, so they are encrypted with the active
+// key and indexed with the current index key. It returns the number of rows.
+func simpanNIK(db *gorm.DB, query *gorm.DB, batch int) (int, error) {
+	count := 0
+	rows := make([]*Pegawai, 0, batch)
+	err := query.Unscoped().Select("id", "nik").FindInBatches(&rows, batch, func(tx *gorm.DB, _ int) error {
+		for _, p := range rows {
+			nik, err := enkripsi.Kolom(p.NIK)
+			if err != nil {
+				return err
+			}
+			// UpdateColumns leaves updated_at and the hooks alone
+			err = db.Unscoped().Model(p).UpdateColumns(map[string]interface{}{
+				"nik":       nik,
+				"nik_index": indeksNIK(p.NIK),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		count += len(rows)
+		return nil
+	}).Error
+	return count, err
+}
+
+// simpanNIKKeluarga re-encrypts the NIK of every family member.
+func simpanNIKKeluarga(db *gorm.DB, batch int) (int, error) {
+	count := 0
+	rows := make([]*Keluarga, 0, batch)
+	err := db.Unscoped().Select("id", "nik").Where("nik IS NOT NULL").FindInBatches(&rows, batch, func(tx *gorm.DB, _ int) error {
+		for _, k := range rows {
+			nik, err := enkripsi.Kolom(k.NIK)
+			if err != nil {
+				return err
+			}
+			if err := db.Unscoped().Model(k).UpdateColumn("nik", nik).Error; err != nil {
+				return err
+			}
+		}
+		count += len(rows)
+		return nil
+	}).Error
+	return count, err
+}
+
+// runRekey re-encrypts every stored NIK with the first key of ENKRIPSI_KEYS
+// and rebuilds the blind indexes. Run it after rotating a key, before the old
+// key is removed from ENKRIPSI_KEYS, and after changing ENKRIPSI_INDEX_KEY.
+//
+//	pegawai-api-2 rekey [-batch 500]
+func runRekey(args []string) {
+	flags := flag.NewFlagSet("rekey", flag.ExitOnError)
+	batch := flags.Int("batch", 500, "rows read per query")
+	flags.Parse(args)
+
+	db, err := initDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pegawai, err := simpanNIK(db, db.Model(&Pegawai{}), *batch)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keluarga, err := simpanNIKKeluarga(db, *batch)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d pegawai and %d keluarga re-encrypted with key %q\n", pegawai, keluarga, enkripsi.KunciAktif())
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"uas/audit"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// RiwayatPendidikan is one degree or certificate held by an employee. The
+// level refers to the pendidikan lookup.
+type RiwayatPendidikan struct {
+	ID            int64          `json:"id"`
+	Pegawai_ID    int64          `gorm:"index" json:"pegawai_id"`
+	Pendidikan_ID int64          `json:"pendidikan_id"`
+	Institusi     string         `json:"institusi"`
+	Jurusan       string         `json:"jurusan"`
+	Tahun_Lulus   int            `json:"tahun_lulus"`
+	Ijazah        string         `json:"ijazah"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (RiwayatPendidikan) TableName() string {
+	return "riwayat_pendidikan"
+}
+
+type RiwayatPendidikanHandler struct {
+	db *gorm.DB
+}
+
+func NewRiwayatPendidikanHandler(db *gorm.DB) *RiwayatPendidikanHandler {
+	return &RiwayatPendidikanHandler{db: db}
+}
+
+type RiwayatPendidikanRequest struct {
+	Pegawai_ID    string `param:"id"`
+	ID            string `param:"riwayat_id"`
+	Pendidikan_ID int64  `json:"pendidikan_id"`
+	Institusi     string `json:"institusi"`
+	Jurusan       string `json:"jurusan"`
+	Tahun_Lulus   int    `json:"tahun_lulus"`
+	Ijazah        string `json:"ijazah"`
+}
+
+func (r *RiwayatPendidikanRequest) validate(db *gorm.DB) (string, error) {
+	if r.Institusi == "" {
+		return "institusi is required", nil
+	}
+	if r.Tahun_Lulus < 0 || r.Tahun_Lulus > time.Now().Year()+10 {
+		return fmt.Sprintf("Invalid tahun_lulus %d", r.Tahun_Lulus), nil
+	}
+	var count int64
+	if err := db.Table("pendidikan").Where("id = ?", r.Pendidikan_ID).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return fmt.Sprintf("Pendidikan %d not found", r.Pendidikan_ID), nil
+	}
+	return "", nil
+}
+
+// syncPendidikan sets Pegawai.Pendidikan_ID to the highest completed level in
+// the employee's education history, ranked by pendidikan.tingkat. An entry is
+// completed once its graduation year has been reached. Employees without any
+// completed entry keep their current value.
+func syncPendidikan(tx *gorm.DB, pegawaiID int64) error {
+	var highest []int64
+	err := tx.Model(&RiwayatPendidikan{}).
+		Joins("JOIN pendidikan ON pendidikan.id = riwayat_pendidikan.pendidikan_id").
+		Where("riwayat_pendidikan.pegawai_id = ?", pegawaiID).
+		Where("riwayat_pendidikan.tahun_lulus BETWEEN 1 AND ?", time.Now().Year()).
+		Order("pendidikan.tingkat DESC").
+		Limit(1).
+		Pluck("riwayat_pendidikan.pendidikan_id", &highest).Error
+	if err != nil || len(highest) == 0 {
+		return err
+	}
+	return tx.Model(&Pegawai{}).Where("id = ?", pegawaiID).Update("pendidikan_id", highest[0]).Error
+}
+
+// syncPendidikanAudit runs syncPendidikan for a change to the education
+// history and records the change it makes to the employee.
+func syncPendidikanAudit(tx *gorm.DB, ctx echo.Context, pegawaiID int64) error {
+	var lama, pegawai Pegawai
+	if err := tx.First(&lama, pegawaiID).Error; err != nil {
+		return err
+	}
+	if err := syncPendidikan(tx, pegawaiID); err != nil {
+		return err
+	}
+	if err := tx.First(&pegawai, pegawaiID).Error; err != nil {
+		return err
+	}
+	return audit.Catat(tx, ctx, "pegawai", pegawaiID, &lama, &pegawai)
+}
+
+func (h *RiwayatPendidikanHandler) GetAllRiwayatPendidikan(ctx echo.Context) error {
+	var input RiwayatPendidikanRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+	if _, err := findPegawai(h.db, input.Pegawai_ID); err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	riwayat := make([]*RiwayatPendidikan, 0)
+	if err := h.db.Where("pegawai_id = ?", input.Pegawai_ID).Order("tahun_lulus").Find(&riwayat).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Riwayat Pendidikan"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Riwayat Pendidikan", "data": riwayat})
+}
+
+func (h *RiwayatPendidikanHandler) GetRiwayatPendidikanByID(ctx echo.Context) error {
+	var input RiwayatPendidikanRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	riwayat := new(RiwayatPendidikan)
+	if err := h.db.Where("id = ? AND pegawai_id = ?", input.ID, input.Pegawai_ID).First(&riwayat).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Riwayat Pendidikan not found"})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Riwayat Pendidikan By ID : %s", input.ID), "data": riwayat})
+}
+
+func (h *RiwayatPendidikanHandler) CreateRiwayatPendidikan(ctx echo.Context) error {
+	var input RiwayatPendidikanRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := findPegawai(h.db, input.Pegawai_ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+	if msg, err := input.validate(h.db); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Riwayat Pendidikan"})
+	} else if msg != "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+	}
+
+	riwayat := RiwayatPendidikan{
+		Pegawai_ID:    pegawai.ID,
+		Pendidikan_ID: input.Pendidikan_ID,
+		Institusi:     input.Institusi,
+		Jurusan:       input.Jurusan,
+		Tahun_Lulus:   input.Tahun_Lulus,
+		Ijazah:        input.Ijazah,
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&riwayat).Error; err != nil {
+			return err
+		}
+		return syncPendidikanAudit(tx, ctx, pegawai.ID)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to create Riwayat Pendidikan"})
+	}
+
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Riwayat Pendidikan created successfully", "data": riwayat})
+}
+
+func (h *RiwayatPendidikanHandler) UpdateRiwayatPendidikan(ctx echo.Context) error {
+	var input RiwayatPendidikanRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := findPegawai(h.db, input.Pegawai_ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	riwayat := new(RiwayatPendidikan)
+	if err := h.db.Where("id = ? AND pegawai_id = ?", input.ID, pegawai.ID).First(&riwayat).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Riwayat Pendidikan not found"})
+	}
+	if msg, err := input.validate(h.db); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Riwayat Pendidikan"})
+	} else if msg != "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+	}
+
+	riwayat.Pendidikan_ID = input.Pendidikan_ID
+	riwayat.Institusi = input.Institusi
+	riwayat.Jurusan = input.Jurusan
+	riwayat.Tahun_Lulus = input.Tahun_Lulus
+	riwayat.Ijazah = input.Ijazah
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&riwayat).Error; err != nil {
+			return err
+		}
+		return syncPendidikanAudit(tx, ctx, pegawai.ID)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update Riwayat Pendidikan"})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Riwayat Pendidikan updated successfully", "data": riwayat})
+}
+
+func (h *RiwayatPendidikanHandler) DeleteRiwayatPendidikan(ctx echo.Context) error {
+	var input RiwayatPendidikanRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	pegawai, err := findPegawai(h.db, input.Pegawai_ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	var rows int64
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND pegawai_id = ?", input.ID, pegawai.ID).Delete(&RiwayatPendidikan{})
+		if result.Error != nil {
+			return result.Error
+		}
+		rows = result.RowsAffected
+		return syncPendidikanAudit(tx, ctx, pegawai.ID)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to delete Riwayat Pendidikan"})
+	}
+	if rows == 0 {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Riwayat Pendidikan not found"})
+	}
+
+	return ctx.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"gorm.io/gorm/schema"
+)
+
+// TestKolom checks the column names used in raw conditions and map updates
+// against the schema GORM derives from the models, as a typo there only
+// fails at runtime.
+func TestKolom(t *testing.T) {
+	tests := []struct {
+		model interface{}
+		kolom []string
+	}{
+		{&Pegawai{}, []string{"id", "nik", "nik_index", "unit", "gambar", "pendidikan_id", "tgl_lahir", "tpt_lahir", "tgl_masuk", "status_pegawai_id", "deleted_at"}},
+		{&Keluarga{}, []string{"id", "nik", "pegawai_id"}},
+		{&RiwayatPendidikan{}, []string{"pegawai_id", "pendidikan_id", "tahun_lulus"}},
+		{&RiwayatStatus{}, []string{"pegawai_id"}},
+		{&UploadSesi{}, []string{"pegawai_id", "offset", "chunks", "kadaluarsa"}},
+		{&Cuti{}, []string{"pegawai_id", "diputus_oleh", "status", "tgl_mulai", "tgl_selesai"}},
+		{&SaldoCuti{}, []string{"pegawai_id", "terpakai", "carry_over"}},
+		{&Presensi{}, []string{"pegawai_id", "tanggal"}},
+		{&KepalaUnit{}, []string{"pegawai_id", "unit"}},
+		{&Pengajuan{}, []string{"pegawai_id", "jenis", "status", "gambar"}},
+	}
+	for _, tt := range tests {
+		s, err := schema.Parse(tt.model, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatalf("parse %T: %v", tt.model, err)
+		}
+		for _, kolom := range tt.kolom {
+			if field := s.LookUpField(kolom); field == nil || field.DBName != kolom {
+				t.Errorf("%s has no column %q", s.Table, kolom)
+			}
+		}
+	}
+}
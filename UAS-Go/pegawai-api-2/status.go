@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"uas/audit"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// StatusPegawai and TransisiStatusPegawai are owned and migrated by
+// status-pegawai-api; this service only reads them.
+type StatusPegawai struct {
+	ID             int64  `json:"id"`
+	Status_Pegawai string `json:"status_pegawai"`
+	Dari_Semua     bool   `json:"dari_semua"`
+	Berhenti       bool   `json:"berhenti"`
+	Aktif          bool   `json:"aktif"`
+}
+
+func (StatusPegawai) TableName() string {
+	return "status_pegawai"
+}
+
+type TransisiStatusPegawai struct {
+	Dari_ID int64
+	Ke_ID   int64
+}
+
+func (TransisiStatusPegawai) TableName() string {
+	return "transisi_status_pegawai"
+}
+
+// RiwayatStatus records every status transition of an employee.
+type RiwayatStatus struct {
+	ID          int64     `json:"id"`
+	Pegawai_ID  int64     `gorm:"index" json:"pegawai_id"`
+	Dari_ID     int64     `json:"dari_id"`
+	Ke_ID       int64     `json:"ke_id"`
+	Alasan      string    `json:"alasan"`
+	Tgl_Berlaku string    `gorm:"size:10" json:"tgl_berlaku"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (RiwayatStatus) TableName() string {
+	return "riwayat_status"
+}
+
+// TransisiStatus is passed to the hooks run on every status transition.
+type TransisiStatus struct {
+	Pegawai *Pegawai
+	Dari    *StatusPegawai
+	Ke      *StatusPegawai
+	Riwayat *RiwayatStatus
+}
+
+// StatusHook runs inside the transaction that changes the status; returning
+// an error rolls the transition back.
+type StatusHook func(tx *gorm.DB, t *TransisiStatus) error
+
+var statusHooks []StatusHook
+
+// onTransisiStatus registers a hook that runs on every status transition.
+func onTransisiStatus(hook StatusHook) {
+	statusHooks = append(statusHooks, hook)
+}
+
+func init() {
+	onTransisiStatus(tutupCutiSaatBerhenti)
+}
+
+// tutupCutiSaatBerhenti closes the leave balances of an employee whose
+// employment ends and rejects their pending leave requests.
+func tutupCutiSaatBerhenti(tx *gorm.DB, t *TransisiStatus) error {
+	if !t.Ke.Berhenti {
+		return nil
+	}
+	if err := tx.Model(&SaldoCuti{}).Where("pegawai_id = ?", t.Pegawai.ID).Update("ditutup", true).Error; err != nil {
+		return err
+	}
+	return tx.Model(&Cuti{}).
+		Where("pegawai_id = ? AND status = ?", t.Pegawai.ID, StatusCutiDiajukan).
+		Updates(map[string]interface{}{"status": StatusCutiDitolak, "catatan": "Pegawai " + t.Ke.Status_Pegawai}).Error
+}
+
+type TransisiStatusRequest struct {
+	Pegawai_ID        string `param:"id"`
+	Status_Pegawai_ID int64  `json:"status_pegawai_id"`
+	Alasan            string `json:"alasan"`
+	Tgl_Berlaku       string `json:"tgl_berlaku"`
+}
+
+// transisiDiizinkan reports whether an employee may move from status dari to
+// ke, either through a configured transition or because ke may be entered
+// from any status. Employees without a status yet move from dari 0.
+func transisiDiizinkan(db *gorm.DB, dari int64, ke *StatusPegawai) (bool, error) {
+	if ke.Dari_Semua {
+		return true, nil
+	}
+	var count int64
+	err := db.Model(&TransisiStatusPegawai{}).Where("dari_id = ? AND ke_id = ?", dari, ke.ID).Count(&count).Error
+	return count > 0, err
+}
+
+// statusAwal checks that new employees may start with status id, which has
+// to be allowed from no status at all. It returns a message for a 400
+// response, or an empty string.
+func statusAwal(db *gorm.DB, id int64) (string, error) {
+	if id == 0 {
+		return "", nil
+	}
+	status := new(StatusPegawai)
+	err := db.First(status, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Sprintf("Status Pegawai %d not found", id), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	allowed, err := transisiDiizinkan(db, 0, status)
+	if err != nil || allowed {
+		return "", err
+	}
+	return fmt.Sprintf("Pegawai can't start with status %q", status.Status_Pegawai), nil
+}
+
+func (h *PegawaiHandler) GetRiwayatStatus(ctx echo.Context) error {
+	pegawai, err := findPegawai(h.db, ctx.Param("id"))
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	riwayat := make([]*RiwayatStatus, 0)
+	if err := h.db.Where("pegawai_id = ?", pegawai.ID).Order("id").Find(&riwayat).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Riwayat Status"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get Riwayat Status", "data": riwayat})
+}
+
+// UbahStatusPegawai moves an employee to a new status as of today or an
+// earlier tgl_berlaku. Inactive statuses can't be entered, and transitions
+// that are not configured on the status lookup are rejected with 409.
+func (h *PegawaiHandler) UbahStatusPegawai(ctx echo.Context) error {
+	var input TransisiStatusRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	if input.Alasan == "" {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "alasan is required"})
+	}
+	if _, err := time.Parse(tglLayout, input.Tgl_Berlaku); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "tgl_berlaku must be formatted as YYYY-MM-DD"})
+	}
+	// The status changes right away, so it can't take effect later
+	if input.Tgl_Berlaku > time.Now().Format(tglLayout) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "tgl_berlaku must not be in the future"})
+	}
+	pegawai, err := findPegawai(h.db, input.Pegawai_ID)
+	if err != nil {
+		return pegawaiLookupError(ctx, err)
+	}
+
+	ke := new(StatusPegawai)
+	if err := h.db.First(ke, input.Status_Pegawai_ID).Error; err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("Status Pegawai %d not found", input.Status_Pegawai_ID)})
+	}
+	if !ke.Aktif {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("Status Pegawai %d is inactive", ke.ID)})
+	}
+	dari := new(StatusPegawai)
+	if err := h.db.First(dari, pegawai.Status_Pegawai_ID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to change Status Pegawai"})
+	}
+	if dari.ID == ke.ID {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": "Pegawai already has status " + ke.Status_Pegawai})
+	}
+
+	allowed, err := transisiDiizinkan(h.db, pegawai.Status_Pegawai_ID, ke)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to change Status Pegawai"})
+	}
+	if !allowed {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": fmt.Sprintf("Transition from %q to %q is not allowed", dari.Status_Pegawai, ke.Status_Pegawai)})
+	}
+
+	riwayat := &RiwayatStatus{
+		Pegawai_ID:  pegawai.ID,
+		Dari_ID:     pegawai.Status_Pegawai_ID,
+		Ke_ID:       ke.ID,
+		Alasan:      input.Alasan,
+		Tgl_Berlaku: input.Tgl_Berlaku,
+	}
+	lama := *pegawai
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Only move from the status that was checked above, in case of a
+		// concurrent transition.
+		result := tx.Model(&Pegawai{}).
+			Where("id = ? AND status_pegawai_id = ?", pegawai.ID, pegawai.Status_Pegawai_ID).
+			Update("status_pegawai_id", ke.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errStatusBerubah
+		}
+		if err := tx.Create(riwayat).Error; err != nil {
+			return err
+		}
+		pegawai.Status_Pegawai_ID = ke.ID
+		if err := audit.Catat(tx, ctx, "pegawai", pegawai.ID, &lama, pegawai); err != nil {
+			return err
+		}
+		t := &TransisiStatus{Pegawai: pegawai, Dari: dari, Ke: ke, Riwayat: riwayat}
+		for _, hook := range statusHooks {
+			if err := hook(tx, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errStatusBerubah) {
+		return ctx.JSON(http.StatusConflict, map[string]string{"message": err.Error()})
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to change Status Pegawai", "error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Status Pegawai changed to " + ke.Status_Pegawai, "data": riwayat})
+}
+
+var errStatusBerubah = errors.New("status pegawai was changed concurrently, please retry")
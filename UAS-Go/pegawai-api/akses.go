@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"uas/auth"
+	"uas/gambar"
+	"uas/privasi"
+
+	"github.com/labstack/echo/v4"
+)
+
+// cekUnit rejects callers whose izin doesn't cover the unit of the Pegawai
+// named by the :id parameter. Unknown IDs are left to the handler.
+func cekUnit(izin string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			akses, err := auth.AksesFrom(DB, c)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
+			}
+			if akses.Global(izin) {
+				return next(c)
+			}
+			var units []string
+			if err := DB.Table("pegawai").Where("id = ?", c.Param("id")).Pluck("unit", &units).Error; err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
+			}
+			if len(units) > 0 && !akses.BolehUnit(izin, units[0]) {
+				return auth.Forbidden(c)
+			}
+			return next(c)
+		}
+	}
+}
+
+// bolehUnit checks izin for employees of unit and writes the error response
+// when it is missing.
+func bolehUnit(c echo.Context, izin, unit string) (bool, error) {
+	akses, err := auth.AksesFrom(DB, c)
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
+	}
+	if !akses.BolehUnit(izin, unit) {
+		return false, auth.Forbidden(c)
+	}
+	return true, nil
+}
+
+// kecualiSigned skips mw for signed photo URLs, which GetGambar verifies.
+func kecualiSigned(mw echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		checked := mw(next)
+		return func(c echo.Context) error {
+			if gambar.Signed(c.QueryParams()) {
+				return next(c)
+			}
+			return checked(c)
+		}
+	}
+}
+
+// tampil writes body as JSON, with the sensitive personal data the caller
+// may not see masked or left out, see package privasi.
+func tampil(c echo.Context, code int, body interface{}) error {
+	akses, err := auth.AksesFrom(DB, c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
+	}
+	body, err = privasi.Untuk(akses).Terapkan(body)
+	if err != nil {
+		log.Print(err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
+	}
+	return c.JSON(code, body)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"uas/gambar"
+)
+
+// runGC reconciles the image storage against Pegawai.Gambar and removes
+// images, thumbnails and staged uploads that no Pegawai or pending change
+// request references.
+//
+//	pegawai-api gc [-dry-run] [-min-age 24h]
+func runGC(args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only list the orphaned files")
+	minAge := flags.Duration("min-age", 24*time.Hour, "keep files younger than this, they may belong to a running request")
+	flags.Parse(args)
+
+	// Soft-deleted rows of pegawai-api-2 still reference their image
+	var names []string
+	if err := DB.Table("pegawai").Where("gambar <> ''").Pluck("gambar", &names).Error; err != nil {
+		log.Fatal(err)
+	}
+	// Photos proposed through self-service wait for HR in pengajuan_perubahan
+	if DB.Migrator().HasTable("pengajuan_perubahan") {
+		var pending []string
+		err := DB.Table("pengajuan_perubahan").Where("status = ? AND gambar <> ''", "diajukan").Pluck("gambar", &pending).Error
+		if err != nil {
+			log.Fatal(err)
+		}
+		names = append(names, pending...)
+	}
+	referenced := make(map[string]bool, len(names))
+	for _, name := range names {
+		referenced[name] = true
+	}
+
+	orphans, err := gambar.GC(context.Background(), Store, referenced, *minAge, *dryRun)
+	for _, name := range orphans {
+		fmt.Println(name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dryRun {
+		fmt.Printf("%d orphaned files found\n", len(orphans))
+	} else {
+		fmt.Printf("%d orphaned files removed\n", len(orphans))
+	}
+}
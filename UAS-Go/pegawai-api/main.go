@@ -1,15 +1,22 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 
+	"uas/audit"
+	"uas/auth"
+	"uas/enkripsi"
+	"uas/gambar"
+	"uas/keamanan"
+	"uas/ratelimit"
+	"uas/storage"
+
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 
@@ -20,6 +27,9 @@ import (
 // DB instance to interact with the database
 var DB *gorm.DB
 
+// Store holds the uploaded employee photos
+var Store storage.Storage
+
 func init() {
 	// Open a database connection using GORM
 	var err error
@@ -31,27 +41,65 @@ func init() {
 
 	fmt.Println("Connected to the database")
 
+	// Open the photo storage configured through STORAGE_DRIVER
+	Store, err = storage.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Run auto migration only during development to create the 'pegawai' table
-	err = DB.AutoMigrate(&Pegawai{})
+	err = DB.AutoMigrate(&Pegawai{}, &auth.TokenDicabut{}, &audit.Log{})
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func main() {
+	// "pegawai-api gc" removes orphaned image files instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		runGC(os.Args[2:])
+		return
+	}
+
+	limiter, err := ratelimit.FromEnv(DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keamananConfig, err := keamanan.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize Echo
 	e := echo.New()
+	e.IPExtractor = limiter.IPExtractor()
 
 	// Middleware
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
+	e.Use(middleware.RequestID())
+	e.Use(keamanan.Middleware(keamananConfig))
+	e.Use(limiter.PerIP(ratelimit.GroupIP))
+	// Signed image URLs are checked by GetGambar itself
+	e.Use(auth.Middleware(DB, func(c echo.Context) bool {
+		return c.Path() == "/pegawai/:id/gambar" && gambar.Signed(c.QueryParams())
+	}))
+	e.Use(limiter.PerClient(ratelimit.GroupDefault))
+
+	// Permissions; routes with :id are also limited to the caller's units
+	baca := auth.Require(DB, auth.PegawaiRead)
+	tulis := auth.Require(DB, auth.PegawaiWrite)
+	bacaUnit := cekUnit(auth.PegawaiRead)
+	tulisUnit := cekUnit(auth.PegawaiWrite)
 
 	// Define API routes
-	e.GET("/pegawai", GetAllData)
-	e.GET("/pegawai/:id", GetPegawaiByID)
-	e.POST("/pegawai", CreatePegawai)
-	e.PUT("/pegawai/:id", UpdatePegawai)
-	e.DELETE("/pegawai/:id", DeletePegawai)
+	e.GET("/pegawai", GetAllData, baca, limiter.PerClient(ratelimit.GroupPegawai))
+	e.GET("/pegawai/:id", GetPegawaiByID, baca, bacaUnit)
+	e.POST("/pegawai", CreatePegawai, tulis)
+	e.PUT("/pegawai/:id", UpdatePegawai, tulis, tulisUnit)
+	e.DELETE("/pegawai/:id", DeletePegawai, tulis, tulisUnit)
+	e.GET("/pegawai/:id/gambar", GetGambar, kecualiSigned(baca), kecualiSigned(bacaUnit))
+	e.GET("/pegawai/:id/gambar/url", GetGambarURL, baca, bacaUnit)
 
 	// Start the server
 	e.Start(":1324")
@@ -59,18 +107,29 @@ func main() {
 
 // Pegawai struct represents the employee data model
 type Pegawai struct {
-	ID             uint   `gorm:"primaryKey" json:"id"`
-	NamaPegawai    string `json:"nama_pegawai"`
-	NIK            string `json:"nik"`
-	JenisPegawaiID int    `json:"jenis_pegawai_id"`
-	Unit           string `json:"unit"`
-	SubUnit        string `json:"sub_unit"`
-	PendidikanID   int    `json:"pendidikan_id"`
-	TanggalLahir   string `json:"tgl_lahir"`
-	TempatLahir    string `json:"tpt_lahir"`
-	JenisKelaminID int    `json:"jenkel_id"`
-	AgamaID        int    `json:"agama_id"`
-	Gambar         string `json:"gambar"`
+	ID             uint    `gorm:"primaryKey" json:"id"`
+	NamaPegawai    string  `json:"nama_pegawai"`
+	NIK            string  `gorm:"size:128;serializer:enkripsi" json:"nik"`
+	NIKIndex       *string `gorm:"column:nik_index;size:64" json:"-"`
+	JenisPegawaiID int     `json:"jenis_pegawai_id"`
+	Unit           string  `json:"unit"`
+	SubUnit        string  `json:"sub_unit"`
+	PendidikanID   int     `json:"pendidikan_id"`
+	TanggalLahir   string  `json:"tgl_lahir"`
+	TempatLahir    string  `json:"tpt_lahir"`
+	JenisKelaminID int     `json:"jenkel_id"`
+	AgamaID        int     `json:"agama_id"`
+	Gambar         string  `json:"gambar"`
+}
+
+// BeforeSave keeps the blind index of the encrypted NIK in sync; NIK is
+// encrypted by the enkripsi serializer and can't be queried directly.
+func (p *Pegawai) BeforeSave(*gorm.DB) error {
+	p.NIKIndex = nil
+	if index := enkripsi.BlindIndex("nik", p.NIK); index != "" {
+		p.NIKIndex = &index
+	}
+	return nil
 }
 
 // PegawaiRequest represents the request payload for creating or updating Pegawai
@@ -91,17 +150,27 @@ type PegawaiRequest struct {
 
 func GetAllData(c echo.Context) error {
 	// Retrieve all employee data
+	// Callers limited to some units only see the employees of those units
+	akses, err := auth.AksesFrom(DB, c)
+	if err != nil {
+		log.Print(err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
+	}
+	query := DB.Table("pegawai")
+	if units, semua := akses.Scope(auth.PegawaiRead); !semua {
+		query = query.Where("unit IN ?", units)
+	}
 	var pegawaiList []Pegawai
-	if err := DB.Table("pegawai").Find(&pegawaiList).Error; err != nil {
+	if err := query.Find(&pegawaiList).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return c.JSON(http.StatusNotFound, map[string]string{"error": "Pegawai not found"})
 		}
-		log.Fatal(err)
+		log.Print(err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
 	}
 
 	// Return the Pegawai data as JSON
-	return c.JSON(http.StatusOK, pegawaiList)
+	return tampil(c, http.StatusOK, pegawaiList)
 }
 
 func GetPegawaiByID(c echo.Context) error {
@@ -117,12 +186,12 @@ func GetPegawaiByID(c echo.Context) error {
 		if err == gorm.ErrRecordNotFound {
 			return c.JSON(http.StatusNotFound, map[string]string{"error": "Pegawai not found"})
 		}
-		log.Fatal(err)
+		log.Print(err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
 	}
 
 	// Return the Pegawai data as JSON
-	return c.JSON(http.StatusOK, pegawai)
+	return tampil(c, http.StatusOK, pegawai)
 }
 
 func CreatePegawai(c echo.Context) error {
@@ -132,34 +201,44 @@ func CreatePegawai(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
 	}
 
-	// Process the uploaded image file
-	file, err := c.FormFile("gambar")
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Image file is required"})
+	// Unit heads may only add employees to their own units
+	if ok, err := bolehUnit(c, auth.PegawaiWrite, request.Unit); !ok {
+		return err
 	}
 
-	// Generate a unique filename for the uploaded image
-	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), file.Filename)
-	uploadPath := filepath.Join("upload", filename)
+	// Reject a NIK that is already registered
+	if request.NIK == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "NIK is required"})
+	}
+	if existing := findByNIK(request.NIK, 0); existing != nil {
+		return nikConflict(c, existing)
+	}
 
-	// Save the image file
-	src, err := file.Open()
-	if err != nil {
-		return err
+	// Reject lookup values that don't exist or are inactive
+	if msg, err := checkLookups(request); err != nil {
+		log.Print(err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
+	} else if msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
 	}
-	defer src.Close()
 
-	dst, err := os.Create(uploadPath)
+	// Process the uploaded image file
+	file, err := c.FormFile("gambar")
 	if err != nil {
-		return err
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Image file is required"})
 	}
-	defer dst.Close()
 
-	if _, err = io.Copy(dst, src); err != nil {
-		return err
+	// Validate and resize the image into the staging area
+	staged, err := gambar.Stage(c.Request().Context(), Store, file)
+	if errors.Is(err, gambar.ErrInvalid) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		log.Print(err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
 	}
 
-	// Create a new Pegawai instance with the image filename
+	// Create a new Pegawai instance with the generated image filename
 	newPegawai := Pegawai{
 		NamaPegawai:    request.NamaPegawai,
 		NIK:            request.NIK,
@@ -171,17 +250,28 @@ func CreatePegawai(c echo.Context) error {
 		TempatLahir:    request.TempatLahir,
 		JenisKelaminID: request.JenisKelaminID,
 		AgamaID:        request.AgamaID,
-		Gambar:         filename, // Save the image filename in the database
+		Gambar:         staged.Name, // Save the image filename in the database
 	}
 
-	// Save the new Pegawai to the database
-	if err := DB.Table("pegawai").Create(&newPegawai).Error; err != nil {
-		log.Fatal(err)
+	// Save the new Pegawai and commit the staged image as the last step of
+	// the transaction, so neither exists without the other
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("pegawai").Create(&newPegawai).Error; err != nil {
+			return err
+		}
+		if err := audit.Catat(tx, c, "pegawai", newPegawai.ID, nil, newPegawai); err != nil {
+			return err
+		}
+		return staged.Commit(c.Request().Context())
+	})
+	if err != nil {
+		staged.Discard(c.Request().Context())
+		log.Print(err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
 	}
 
 	// Return the created Pegawai as JSON
-	return c.JSON(http.StatusCreated, newPegawai)
+	return tampil(c, http.StatusCreated, newPegawai)
 }
 
 func UpdatePegawai(c echo.Context) error {
@@ -203,39 +293,39 @@ func UpdatePegawai(c echo.Context) error {
 		if err == gorm.ErrRecordNotFound {
 			return c.JSON(http.StatusNotFound, map[string]string{"error": "Pegawai not found"})
 		}
-		log.Fatal(err)
+		log.Print(err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
 	}
 
-	// Process the updated image file, if any
-	file, err := c.FormFile("gambar")
-	if err == nil {
-		// If a new image is uploaded, generate a unique filename for it
-		filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), file.Filename)
-		uploadPath := filepath.Join("uploads", filename)
+	// cekUnit checked the current unit; the new one must be allowed too
+	if ok, err := bolehUnit(c, auth.PegawaiWrite, request.Unit); !ok {
+		return err
+	}
 
-		// Save the new image file
-		src, err := file.Open()
-		if err != nil {
-			return err
-		}
-		defer src.Close()
+	// Reject a NIK that is already registered to another Pegawai
+	if request.NIK == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "NIK is required"})
+	}
+	if existing := findByNIK(request.NIK, existingPegawai.ID); existing != nil {
+		return nikConflict(c, existing)
+	}
 
-		dst, err := os.Create(uploadPath)
-		if err != nil {
-			return err
+	// Process the updated image file, if any
+	var staged *gambar.Staged
+	if file, err := c.FormFile("gambar"); err == nil {
+		// If a new image is uploaded, validate it into the staging area
+		staged, err = gambar.Stage(c.Request().Context(), Store, file)
+		if errors.Is(err, gambar.ErrInvalid) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 		}
-		defer dst.Close()
-
-		if _, err = io.Copy(dst, src); err != nil {
-			return err
+		if err != nil {
+			log.Print(err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
 		}
-
-		// Update the image filename in the database
-		existingPegawai.Gambar = filename
 	}
 
-	// Update the existing Pegawai
+	// Update the existing Pegawai, keeping the old values for the audit log
+	lama := existingPegawai
 	existingPegawai.NamaPegawai = request.NamaPegawai
 	existingPegawai.NIK = request.NIK
 	existingPegawai.JenisPegawaiID = request.JenisPegawaiID
@@ -247,14 +337,40 @@ func UpdatePegawai(c echo.Context) error {
 	existingPegawai.JenisKelaminID = request.JenisKelaminID
 	existingPegawai.AgamaID = request.AgamaID
 
-	// Save the updated Pegawai to the database
-	if err := DB.Table("pegawai").Save(&existingPegawai).Error; err != nil {
-		log.Fatal(err)
+	// Update the image filename, keeping the old one to remove afterwards
+	oldGambar := existingPegawai.Gambar
+	if staged != nil {
+		existingPegawai.Gambar = staged.Name
+	}
+
+	// Save the updated Pegawai and commit the staged image, if any, in one go
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("pegawai").Save(&existingPegawai).Error; err != nil {
+			return err
+		}
+		if err := audit.Catat(tx, c, "pegawai", existingPegawai.ID, lama, existingPegawai); err != nil {
+			return err
+		}
+		if staged == nil {
+			return nil
+		}
+		return staged.Commit(c.Request().Context())
+	})
+	if err != nil {
+		if staged != nil {
+			staged.Discard(c.Request().Context())
+		}
+		log.Print(err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
 	}
 
+	// The old image is no longer referenced
+	if staged != nil && oldGambar != "" {
+		removeGambar(c, oldGambar)
+	}
+
 	// Return the updated Pegawai as JSON
-	return c.JSON(http.StatusOK, existingPegawai)
+	return tampil(c, http.StatusOK, existingPegawai)
 }
 
 func DeletePegawai(c echo.Context) error {
@@ -270,25 +386,154 @@ func DeletePegawai(c echo.Context) error {
 		if err == gorm.ErrRecordNotFound {
 			return c.JSON(http.StatusNotFound, map[string]string{"message": "Pegawai not found"})
 		}
-		log.Fatal(err)
+		log.Print(err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
 	}
 
-	// Delete the Pegawai from the database
-	if err := DB.Table("pegawai").Delete(&pegawai).Error; err != nil {
-		log.Fatal(err)
+	// Delete the Pegawai from the database together with its audit entry
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("pegawai").Delete(&pegawai).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, c, "pegawai", pegawai.ID, pegawai, nil)
+	})
+	if err != nil {
+		log.Print(err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
 	}
 
-	// Delete the associated image file
-	imagePath := filepath.Join("uploads", pegawai.Gambar)
-	if err := os.Remove(imagePath); err != nil {
-		log.Fatal(err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
-	}
+	// Delete the associated image file and its thumbnails
+	removeGambar(c, pegawai.Gambar)
 
 	// Return success message
 	return c.JSON(http.StatusOK, map[string]string{"message": "Pegawai deleted successfully"})
 }
 
+// removeGambar deletes an image that is no longer referenced. The database
+// change has already happened, so failures are only logged; leftovers are
+// removed by the gc command.
+func removeGambar(c echo.Context, name string) {
+	if name == "" {
+		return
+	}
+	err := gambar.Delete(c.Request().Context(), Store, name)
+	if errors.Is(err, storage.ErrNotExist) {
+		log.Printf("warning: image file %s was already missing", name)
+	} else if err != nil {
+		log.Printf("warning: failed to remove image file %s: %v", name, err)
+	}
+}
+
+// GetGambar streams the photo of a Pegawai. ?size= selects a thumbnail
+// (see gambar.SizeNames); requests carrying a signature from GetGambarURL
+// must have a valid, unexpired one.
+func GetGambar(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID"})
+	}
+	if gambar.Signed(c.QueryParams()) && !gambar.Verify(c.Param("id"), c.QueryParams()) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Invalid or expired signature"})
+	}
 
+	var pegawai Pegawai
+	if err := DB.Table("pegawai").First(&pegawai, id).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Pegawai not found"})
+	}
+	if pegawai.Gambar == "" {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Pegawai has no image"})
+	}
+
+	err = gambar.Serve(c.Response(), c.Request(), Store, pegawai.Gambar, c.QueryParam("size"))
+	if errors.Is(err, gambar.ErrSize) {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "Unknown size", "sizes": gambar.SizeNames()})
+	}
+	if errors.Is(err, storage.ErrNotExist) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Image file not found"})
+	}
+	if err != nil {
+		log.Print(err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal Server Error"})
+	}
+	return nil
+}
+
+// GetGambarURL returns a signed URL for the photo of a Pegawai that stays
+// valid for ?ttl= seconds (default 15 minutes, at most a day).
+func GetGambarURL(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID"})
+	}
+	size := c.QueryParam("size")
+	if _, ok := gambar.Key("", size); !ok {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "Unknown size", "sizes": gambar.SizeNames()})
+	}
+	ttl := 15 * time.Minute
+	if value := c.QueryParam("ttl"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 || seconds > 86400 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "ttl must be between 1 and 86400 seconds"})
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	var pegawai Pegawai
+	if err := DB.Table("pegawai").First(&pegawai, id).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Pegawai not found"})
+	}
+
+	expires := time.Now().Add(ttl)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"url":        fmt.Sprintf("/pegawai/%d/gambar?%s", pegawai.ID, gambar.SignedQuery(fmt.Sprint(pegawai.ID), size, expires)),
+		"expires_at": expires,
+	})
+}
+
+// findByNIK returns the Pegawai, other than exceptID, that already uses nik,
+// or nil when the NIK is free.
+func findByNIK(nik string, exceptID uint) *Pegawai {
+	var existing Pegawai
+	index := enkripsi.BlindIndex("nik", nik)
+	if err := DB.Table("pegawai").Unscoped().Where("nik_index = ? AND id <> ?", index, exceptID).First(&existing).Error; err != nil {
+		return nil
+	}
+	return &existing
+}
+
+// nikConflict writes a 409 response naming the Pegawai that holds the NIK.
+func nikConflict(c echo.Context, existing *Pegawai) error {
+	return c.JSON(http.StatusConflict, map[string]interface{}{
+		"error":   fmt.Sprintf("NIK is already registered to Pegawai %d", existing.ID),
+		"pegawai": map[string]interface{}{"id": existing.ID, "nama_pegawai": existing.NamaPegawai},
+	})
+}
+
+// checkLookups verifies that every lookup ID set in the request refers to an
+// active row. It returns a message for a 400 response, or an empty string,
+// and an error when the lookup fails.
+func checkLookups(request PegawaiRequest) (string, error) {
+	lookups := []struct {
+		table string
+		label string
+		id    int
+	}{
+		{"jenis_pegawai", "Jenis Pegawai", request.JenisPegawaiID},
+		{"pendidikan", "Pendidikan", request.PendidikanID},
+		{"jenis_kelamin", "Jenis Kelamin", request.JenisKelaminID},
+		{"agama", "Agama", request.AgamaID},
+	}
+	for _, l := range lookups {
+		if l.id == 0 {
+			continue
+		}
+		var aktif []bool
+		if err := DB.Table(l.table).Where("id = ?", l.id).Pluck("aktif", &aktif).Error; err != nil {
+			return "", err
+		}
+		if len(aktif) == 0 || !aktif[0] {
+			return fmt.Sprintf("%s %d not found or inactive", l.label, l.id), nil
+		}
+	}
+	return "", nil
+}
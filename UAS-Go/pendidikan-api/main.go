@@ -8,7 +8,14 @@ import (
 	"strconv"
 	"time"
 
+	"uas/audit"
+	"uas/auth"
+	"uas/i18n"
+	"uas/keamanan"
+	"uas/ratelimit"
+
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -32,10 +39,10 @@ func initDB() (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = db.AutoMigrate(&Pendidikan{})
-    if err != nil {
-        return nil, err
-    }
+	err = db.AutoMigrate(&Pendidikan{}, &i18n.Terjemahan{}, &auth.TokenDicabut{}, &audit.Log{})
+	if err != nil {
+		return nil, err
+	}
 
 	return db, nil
 }
@@ -46,24 +53,49 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	limiter, err := ratelimit.FromEnv(db)
+	if err != nil {
+		panic(err)
+	}
+	keamananConfig, err := keamanan.FromEnv()
+	if err != nil {
+		panic(err)
+	}
 	// inisialisasi handler
 	pendidikanHandler := NewPendidikanHandler(db)
+	terjemahanHandler := i18n.NewTerjemahanHandler(db, "pendidikan")
 
 	e := echo.New()
+	e.IPExtractor = limiter.IPExtractor()
+	e.Use(middleware.RequestID())
+	e.Use(keamanan.Middleware(keamananConfig))
+	e.Use(limiter.PerIP(ratelimit.GroupIP))
+	e.Use(auth.Middleware(db))
+	e.Use(limiter.PerClient(ratelimit.GroupDefault))
+	e.Use(auth.RequireLookup(db))
 	// routing
 	e.GET("/pendidikan", pendidikanHandler.GetAllPendidikan)
 	e.GET("/pendidikan/:id", pendidikanHandler.GetPendidikanByID)
 	e.POST("/pendidikan", pendidikanHandler.CreatePendidikan)
 	e.PUT("/pendidikan/:id", pendidikanHandler.UpdatePendidikan)
 	e.DELETE("/pendidikan/:id", pendidikanHandler.DeletePendidikan)
+	e.GET("/pendidikan/:id/terjemahan", terjemahanHandler.GetAllTerjemahan)
+	e.PUT("/pendidikan/:id/terjemahan/:locale", terjemahanHandler.SetTerjemahan)
+	e.DELETE("/pendidikan/:id/terjemahan/:locale", terjemahanHandler.DeleteTerjemahan)
 	e.Logger.Fatal(e.Start(":1882"))
 }
 
+// Pendidikan is a level of education. Tingkat ranks the levels to find an
+// employee's highest one, higher is better.
 type Pendidikan struct {
-	ID     		 int64  `json:"id"`
-	Pendidikan   string `json:"pendidikan"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         int64     `json:"id"`
+	Pendidikan string    `json:"pendidikan"`
+	Tingkat    int       `json:"tingkat"`
+	Kode       string    `gorm:"size:20" json:"kode"`
+	Urutan     int       `json:"urutan"`
+	Aktif      bool      `gorm:"default:true" json:"aktif"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
 }
 
 func (Pendidikan) TableName() string {
@@ -79,20 +111,30 @@ func NewPendidikanHandler(db *gorm.DB) *PendidikanHandler {
 }
 
 type PendidikanRequest struct {
-	ID  		  string `param:"id"`
-	Pendidikan    string `json:"pendidikan"`
+	ID         string `param:"id"`
+	Pendidikan string `json:"pendidikan"`
+	Tingkat    int    `json:"tingkat"`
+	Kode       string `json:"kode"`
+	Urutan     int    `json:"urutan"`
+	Aktif      *bool  `json:"aktif"`
 }
 
 func (h *PendidikanHandler) GetAllPendidikan(ctx echo.Context) error {
 	search := ctx.QueryParam("search")
 	pendidikan := make([]*Pendidikan, 0)
-	query := h.db.Model(&Pendidikan{})
+	query := h.db.Model(&Pendidikan{}).Order("urutan").Order("id")
+	if ctx.QueryParam("include_inactive") != "true" {
+		query = query.Where("aktif = ?", true)
+	}
 	if search != "" {
 		query = query.Where("pendidikan LIKE ?", "%"+search+"%")
 	}
 	if err := query.Find(&pendidikan).Error; err != nil { // SELECT * FROM users
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Pendidikan"})
 	}
+	if err := i18n.Apply(h.db, "pendidikan", i18n.Locales(ctx), pendidikan, func(x *Pendidikan) (int64, *string) { return x.ID, &x.Pendidikan }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Pendidikan"})
+	}
 	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Users", "data": pendidikan, "filter": search})
 }
 
@@ -103,11 +145,26 @@ func (h *PendidikanHandler) CreatePendidikan(ctx echo.Context) error {
 	}
 
 	pendidikan := &Pendidikan{
-		Pendidikan:    input.Pendidikan,
-		CreatedAt: time.Now(),
+		Pendidikan: input.Pendidikan,
+		Kode:       input.Kode,
+		Urutan:     input.Urutan,
+		Tingkat:    input.Tingkat,
+		CreatedAt:  time.Now(),
 	}
 
-	if err := h.db.Create(pendidikan).Error; err != nil { // INSERT INTO users (nim, nama, alamat) VALUES('')
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(pendidikan).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil && !*input.Aktif {
+			if err := tx.Model(pendidikan).Update("aktif", false).Error; err != nil {
+				return err
+			}
+			pendidikan.Aktif = false
+		}
+		return audit.Catat(tx, ctx, "pendidikan", pendidikan.ID, nil, pendidikan)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Create Pendidikan"})
 	}
 
@@ -125,6 +182,9 @@ func (h *PendidikanHandler) GetPendidikanByID(ctx echo.Context) error {
 	if err := h.db.Where("id =?", input.ID).First(&pendidikan).Error; err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Pendidikan By ID"})
 	}
+	if err := i18n.Apply(h.db, "pendidikan", i18n.Locales(ctx), []*Pendidikan{pendidikan}, func(x *Pendidikan) (int64, *string) { return x.ID, &x.Pendidikan }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Pendidikan By ID"})
+	}
 
 	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Pendidikan By ID : %s", input.ID), "data": pendidikan})
 }
@@ -138,13 +198,35 @@ func (h *PendidikanHandler) UpdatePendidikan(ctx echo.Context) error {
 	pendidikanID, _ := strconv.Atoi(input.ID)
 
 	pendidikan := Pendidikan{
-		ID:     int64(pendidikanID),
-		Pendidikan:    input.Pendidikan,
-		UpdatedAt: time.Now(),
+		ID:         int64(pendidikanID),
+		Pendidikan: input.Pendidikan,
+		Kode:       input.Kode,
+		Urutan:     input.Urutan,
+		Tingkat:    input.Tingkat,
+		UpdatedAt:  time.Now(),
 	}
 
-	query := h.db.Model(&Pendidikan{}).Where("id = ?", pendidikanID)
-	if err := query.Updates(&pendidikan).Error; err != nil {
+	sebelum := new(Pendidikan)
+	if err := h.db.First(sebelum, pendidikanID).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pendidikan not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&Pendidikan{}).Where("id = ?", pendidikanID).Select("pendidikan", "tingkat", "kode", "urutan", "updated_at")
+		if err := query.Updates(&pendidikan).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil {
+			if err := tx.Model(&Pendidikan{}).Where("id = ?", pendidikanID).Update("aktif", *input.Aktif).Error; err != nil {
+				return err
+			}
+		}
+		sesudah := new(Pendidikan)
+		if err := tx.First(sesudah, pendidikanID).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "pendidikan", pendidikanID, sebelum, sesudah)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Update Pendidikan By ID", "error": err.Error()})
 	}
 
@@ -157,8 +239,18 @@ func (h *PendidikanHandler) DeletePendidikan(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
 	}
 
-	if err := h.db.Delete(&Pendidikan{}, input.ID).Error; err != nil {
+	sebelum := new(Pendidikan)
+	if err := h.db.Where("id = ?", input.ID).First(sebelum).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Pendidikan not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(sebelum).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "pendidikan", sebelum.ID, sebelum, nil)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Delete Pendidikan By ID"})
 	}
 	return ctx.JSON(http.StatusNoContent, nil)
-}
\ No newline at end of file
+}
@@ -0,0 +1,155 @@
+// Package privasi hides sensitive personal data, as defined by Indonesia's
+// personal data protection law (UU PDP), from callers that may not see it.
+// The rules work on JSON field names, so they apply the same way to every
+// response that contains employees or their family, at any depth.
+package privasi
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"uas/auth"
+)
+
+// Mode is how a field is shown to callers without its permission.
+type Mode int
+
+const (
+	// Samar shows the value partly, see Samarkan.
+	Samar Mode = iota + 1
+	// Sembunyi leaves the field out.
+	Sembunyi
+)
+
+// Aturan is the visibility rule of one field. Callers with Izin or with
+// auth.PegawaiSensitif see the field in full; others see it as Mode.
+type Aturan struct {
+	Field string
+	Izin  string
+	Mode  Mode
+}
+
+// aturan are the rules for the sensitive fields, by JSON name.
+var aturan = map[string]Aturan{
+	"nik":       {"nik", auth.PegawaiSensitif + ":nik", Samar},
+	"tgl_lahir": {"tgl_lahir", auth.PegawaiSensitif + ":tgl_lahir", Samar},
+	"tpt_lahir": {"tpt_lahir", auth.PegawaiSensitif + ":tpt_lahir", Sembunyi},
+	"agama_id":  {"agama_id", auth.PegawaiSensitif + ":agama_id", Sembunyi},
+	"alamat":    {"alamat", auth.PegawaiSensitif + ":alamat", Sembunyi},
+}
+
+// Visibilitas is what a caller may see of the sensitive fields.
+type Visibilitas map[string]Mode
+
+// Untuk returns the visibility for akses. A nil Akses sees nothing in full.
+// Responses can hold employees of several units, so only permissions granted
+// without unit restriction count; unit-limited grants see the fields masked.
+func Untuk(akses *auth.Akses) Visibilitas {
+	v := make(Visibilitas)
+	for field, a := range aturan {
+		if akses == nil || !(akses.Global(auth.PegawaiSensitif) || akses.Global(a.Izin)) {
+			v[field] = a.Mode
+		}
+	}
+	return v
+}
+
+// Penuh reports whether every field is visible in full.
+func (v Visibilitas) Penuh() bool {
+	return len(v) == 0
+}
+
+// Nilai returns field as the caller may see it; ok is false when the field
+// must be left out. It is meant for exports written field by field.
+func (v Visibilitas) Nilai(field, value string) (s string, ok bool) {
+	switch v[field] {
+	case Samar:
+		return samarkan(field, value), true
+	case Sembunyi:
+		return "", false
+	}
+	return value, true
+}
+
+// Terapkan returns body with the fields the caller may not see masked or
+// removed, ready to be encoded as JSON. body is returned unchanged when
+// everything is visible.
+func (v Visibilitas) Terapkan(body interface{}) (interface{}, error) {
+	if v.Penuh() {
+		return body, nil
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var out interface{}
+	if err := d.Decode(&out); err != nil {
+		return nil, err
+	}
+	v.walk(out)
+	return out, nil
+}
+
+func (v Visibilitas) walk(x interface{}) {
+	switch x := x.(type) {
+	case map[string]interface{}:
+		for k, val := range x {
+			switch v[k] {
+			case Samar:
+				x[k] = samarkanNilai(k, val)
+			case Sembunyi:
+				delete(x, k)
+			default:
+				v.walk(val)
+			}
+		}
+	case []interface{}:
+		for _, val := range x {
+			v.walk(val)
+		}
+	}
+}
+
+// samarkanNilai masks the strings in val, which may be nested, e.g. the
+// before and after values in an audit entry.
+func samarkanNilai(field string, val interface{}) interface{} {
+	switch val := val.(type) {
+	case string:
+		return samarkan(field, val)
+	case map[string]interface{}:
+		for k, x := range val {
+			val[k] = samarkanNilai(field, x)
+		}
+		return val
+	case []interface{}:
+		for i, x := range val {
+			val[i] = samarkanNilai(field, x)
+		}
+		return val
+	case nil:
+		return nil
+	}
+	return samarkan(field, "")
+}
+
+// samarkan masks value: dates keep the year, other values their first and
+// last four characters, e.g. 3174********0001.
+func samarkan(field, value string) string {
+	if value == "" {
+		return ""
+	}
+	if strings.HasPrefix(field, "tgl_") {
+		if len(value) >= 4 {
+			return value[:4] + "-**-**"
+		}
+		return "****-**-**"
+	}
+	r := []rune(value)
+	if len(r) <= 8 {
+		return strings.Repeat("*", len(r))
+	}
+	return string(r[:4]) + strings.Repeat("*", len(r)-8) + string(r[len(r)-4:])
+}
@@ -0,0 +1,95 @@
+package privasi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSamarkan(t *testing.T) {
+	tests := []struct {
+		field string
+		value string
+		want  string
+	}{
+		{"nik", "", ""},
+		{"nik", "3174010101010001", "3174********0001"},
+		{"nik", "123456789", "1234*6789"},
+		{"nik", "12345678", "********"},
+		{"nik", "ÄÖÜ", "***"},
+		{"tgl_lahir", "1990-05-17", "1990-**-**"},
+		{"tgl_lahir", "90", "****-**-**"},
+	}
+	for _, tt := range tests {
+		if got := samarkan(tt.field, tt.value); got != tt.want {
+			t.Errorf("samarkan(%q, %q) = %q; want %q", tt.field, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestTerapkan(t *testing.T) {
+	type pegawai struct {
+		Nama      string `json:"nama_pegawai"`
+		NIK       string `json:"nik"`
+		Tgl_Lahir string `json:"tgl_lahir"`
+		Tpt_Lahir string `json:"tpt_lahir"`
+		Alamat    string `json:"alamat"`
+		Gaji      int64  `json:"gaji"`
+	}
+	body := map[string]interface{}{
+		"message": "ok",
+		"data": []pegawai{{
+			Nama:      "Budi",
+			NIK:       "3174010101010001",
+			Tgl_Lahir: "1990-05-17",
+			Tpt_Lahir: "Jakarta",
+			Alamat:    "Jl. Merdeka No. 1",
+			Gaji:      9007199254740993,
+		}},
+		// Audit entries hold the before and after values of a field
+		"perubahan": map[string]interface{}{
+			"nik": map[string]interface{}{"sebelum": "3174010101010001", "sesudah": nil},
+		},
+	}
+	tests := []struct {
+		name string
+		v    Visibilitas
+		want string
+	}{
+		// Returned as it is, so the fields keep the order of the struct
+		{"full", Visibilitas{}, `{"data":[{"nama_pegawai":"Budi","nik":"3174010101010001","tgl_lahir":"1990-05-17","tpt_lahir":"Jakarta","alamat":"Jl. Merdeka No. 1","gaji":9007199254740993}],"message":"ok","perubahan":{"nik":{"sebelum":"3174010101010001","sesudah":null}}}`},
+		{"nothing", Untuk(nil), `{"data":[{"gaji":9007199254740993,"nama_pegawai":"Budi","nik":"3174********0001","tgl_lahir":"1990-**-**"}],"message":"ok","perubahan":{"nik":{"sebelum":"3174********0001","sesudah":null}}}`},
+		{"only the address hidden", Visibilitas{"alamat": Sembunyi}, `{"data":[{"gaji":9007199254740993,"nama_pegawai":"Budi","nik":"3174010101010001","tgl_lahir":"1990-05-17","tpt_lahir":"Jakarta"}],"message":"ok","perubahan":{"nik":{"sebelum":"3174010101010001","sesudah":null}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.v.Terapkan(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := json.Marshal(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("Terapkan() = %s\nwant %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilai(t *testing.T) {
+	v := Untuk(nil)
+	tests := []struct {
+		field, value, want string
+		ok                 bool
+	}{
+		{"nama_pegawai", "Budi", "Budi", true},
+		{"nik", "3174010101010001", "3174********0001", true},
+		{"agama_id", "1", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := v.Nilai(tt.field, tt.value); got != tt.want || ok != tt.ok {
+			t.Errorf("Nilai(%q, %q) = %q, %v; want %q, %v", tt.field, tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
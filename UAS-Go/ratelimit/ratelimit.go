@@ -0,0 +1,244 @@
+// Package ratelimit limits how often a client may call the services, so a
+// single misbehaving script can't saturate the database. Every route group
+// has a token-bucket Policy: a client may make Burst requests at once and
+// gets Rate new ones per second after that. Clients are told their budget in
+// RateLimit-* headers and get 429 with Retry-After when it is used up.
+//
+// Buckets live in memory by default, which limits each instance on its own.
+// Deployments with several instances behind a load balancer share the
+// buckets in the database instead, see FromEnv.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"uas/auth"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Route groups used by the services. Every request is limited per IP in
+// GroupIP before it is authenticated and per client in GroupDefault after;
+// expensive routes add their own group.
+const (
+	GroupIP      = "ip"
+	GroupDefault = "default"
+	GroupPegawai = "pegawai"
+	GroupLogin   = "login"
+)
+
+// Policy is a token bucket: Burst requests at once, refilled with Rate
+// requests per second. A Policy with Rate 0 doesn't limit anything.
+type Policy struct {
+	Rate  float64
+	Burst int
+}
+
+// window is the time an empty bucket takes to fill up again.
+func (p Policy) window() time.Duration {
+	return time.Duration(float64(p.Burst) / p.Rate * float64(time.Second))
+}
+
+// Hasil is the outcome of taking a token from a bucket.
+type Hasil struct {
+	Boleh  bool          // a token was available
+	Sisa   int           // tokens left
+	Reset  time.Duration // until the bucket is full again
+	Tunggu time.Duration // until the next token, when Boleh is false
+}
+
+// Store keeps the buckets.
+type Store interface {
+	// Take takes a token from the bucket key, refilled according to p
+	// since it was last used.
+	Take(ctx context.Context, key string, p Policy, now time.Time) (Hasil, error)
+}
+
+// take applies p to a bucket with tokens left at last, and returns the
+// tokens left now.
+func take(tokens float64, last time.Time, p Policy, now time.Time) (float64, Hasil) {
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(float64(p.Burst), tokens+elapsed*p.Rate)
+	}
+	var hasil Hasil
+	if tokens >= 1 {
+		tokens--
+		hasil.Boleh = true
+	} else {
+		hasil.Tunggu = time.Duration((1 - tokens) / p.Rate * float64(time.Second))
+	}
+	hasil.Sisa = int(tokens)
+	hasil.Reset = time.Duration((float64(p.Burst) - tokens) / p.Rate * float64(time.Second))
+	return tokens, hasil
+}
+
+// DefaultPolicies apply to groups RATELIMIT_POLICIES doesn't configure.
+var DefaultPolicies = map[string]Policy{
+	GroupIP:      {Rate: 50, Burst: 100},
+	GroupDefault: {Rate: 10, Burst: 30},
+	GroupPegawai: {Rate: 2, Burst: 10},
+	GroupLogin:   {Rate: 10.0 / 60, Burst: 5},
+}
+
+// Limiter applies the policies of the route groups.
+type Limiter struct {
+	store      Store
+	policies   map[string]Policy
+	trustProxy bool
+}
+
+// New returns a Limiter keeping its buckets in store. Groups missing from
+// policies use DefaultPolicies.
+func New(store Store, policies map[string]Policy) *Limiter {
+	l := &Limiter{store: store, policies: make(map[string]Policy)}
+	for group, p := range DefaultPolicies {
+		l.policies[group] = p
+	}
+	for group, p := range policies {
+		l.policies[group] = p
+	}
+	return l
+}
+
+// FromEnv builds the Limiter configured in the environment:
+//
+//	RATELIMIT_STORE          memory (default), or db to share the buckets
+//	                         between instances in the rate_limit table of db
+//	RATELIMIT_POLICIES       policies as group=<n>/<s|m|h>[:burst], e.g.
+//	                         "pegawai=60/m:20,login=5/m"; the burst defaults
+//	                         to n and a rate of 0 turns the group off
+//	RATELIMIT_TRUST_PROXY    true to take the client IP from X-Forwarded-For,
+//	                         only behind a proxy that sets it, see IPExtractor
+func FromEnv(db *gorm.DB) (*Limiter, error) {
+	policies, err := ParsePolicies(os.Getenv("RATELIMIT_POLICIES"))
+	if err != nil {
+		return nil, err
+	}
+	var store Store
+	switch driver := os.Getenv("RATELIMIT_STORE"); driver {
+	case "", "memory":
+		store = NewMemory()
+	case "db":
+		if store, err = NewDB(db); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown RATELIMIT_STORE %q", driver)
+	}
+	l := New(store, policies)
+	l.trustProxy, _ = strconv.ParseBool(os.Getenv("RATELIMIT_TRUST_PROXY"))
+	return l, nil
+}
+
+// ParsePolicies parses policies in the format of RATELIMIT_POLICIES.
+func ParsePolicies(value string) (map[string]Policy, error) {
+	policies := make(map[string]Policy)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, spec, ok := strings.Cut(entry, "=")
+		if !ok || group == "" {
+			return nil, fmt.Errorf("ratelimit: invalid policy %q", entry)
+		}
+		spec, burst, hasBurst := strings.Cut(spec, ":")
+		count, unit, ok := strings.Cut(spec, "/")
+		n, err := strconv.Atoi(count)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("ratelimit: invalid policy %q", entry)
+		}
+		per := map[string]float64{"s": 1, "m": 60, "h": 3600}[unit]
+		if per == 0 {
+			return nil, fmt.Errorf("ratelimit: invalid unit in policy %q", entry)
+		}
+		p := Policy{Rate: float64(n) / per, Burst: n}
+		if hasBurst {
+			if p.Burst, err = strconv.Atoi(burst); err != nil || p.Burst < 1 {
+				return nil, fmt.Errorf("ratelimit: invalid burst in policy %q", entry)
+			}
+		}
+		policies[group] = p
+	}
+	return policies, nil
+}
+
+// PerIP limits requests of group per client IP. It can run before
+// authentication, e.g. to protect the login.
+func (l *Limiter) PerIP(group string) echo.MiddlewareFunc {
+	return l.middleware(group, func(ctx echo.Context) string {
+		return "ip:" + l.ip(ctx)
+	})
+}
+
+// PerClient limits requests of group per API key or user, and per IP for
+// requests without either. It must run after auth.Middleware.
+func (l *Limiter) PerClient(group string) echo.MiddlewareFunc {
+	return l.middleware(group, func(ctx echo.Context) string {
+		if apiKey := auth.ApiKeyFromContext(ctx); apiKey != nil {
+			return "key:" + strconv.FormatInt(apiKey.ID, 10)
+		}
+		if claims := auth.FromContext(ctx); claims != nil {
+			return "user:" + strconv.FormatInt(claims.UserID(), 10)
+		}
+		return "ip:" + l.ip(ctx)
+	})
+}
+
+// IPExtractor finds the client IP as configured with RATELIMIT_TRUST_PROXY.
+// Services set it as echo.Echo.IPExtractor, so ctx.RealIP() returns the same
+// IP to the limiter and to the audit log, and a client can't choose its IP
+// with X-Forwarded-For unless a trusted proxy sets the header.
+func (l *Limiter) IPExtractor() echo.IPExtractor {
+	if l.trustProxy {
+		return echo.ExtractIPFromXFFHeader()
+	}
+	return echo.ExtractIPDirect()
+}
+
+func (l *Limiter) ip(ctx echo.Context) string {
+	return l.IPExtractor()(ctx.Request())
+}
+
+// middleware takes a token from the bucket of group and the client named by
+// client. When the store fails the request is let through, as an outage of
+// the limiter shouldn't take the services down with it.
+func (l *Limiter) middleware(group string, client func(echo.Context) string) echo.MiddlewareFunc {
+	p := l.policies[group]
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if p.Rate <= 0 || p.Burst < 1 {
+			return next
+		}
+		return func(ctx echo.Context) error {
+			hasil, err := l.store.Take(ctx.Request().Context(), group+":"+client(ctx), p, time.Now())
+			if err != nil {
+				log.Printf("ratelimit: %v", err)
+				return next(ctx)
+			}
+			header := ctx.Response().Header()
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Burst, detik(p.window())))
+			header.Set("RateLimit-Limit", strconv.Itoa(p.Burst))
+			header.Set("RateLimit-Remaining", strconv.Itoa(hasil.Sisa))
+			header.Set("RateLimit-Reset", strconv.Itoa(detik(hasil.Reset)))
+			if !hasil.Boleh {
+				header.Set(echo.HeaderRetryAfter, strconv.Itoa(detik(hasil.Tunggu)))
+				return ctx.JSON(http.StatusTooManyRequests, map[string]string{"message": "Too many requests, please retry later"})
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// detik rounds d up to whole seconds, as the headers don't allow fractions.
+func detik(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	p := Policy{Rate: 2, Burst: 4}
+	last := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		left    float64
+		hasil   Hasil
+	}{
+		{"full bucket", 4, 0, 3, Hasil{Boleh: true, Sisa: 3, Reset: 500 * time.Millisecond}},
+		{"last token", 1, 0, 0, Hasil{Boleh: true, Sisa: 0, Reset: 2 * time.Second}},
+		{"empty bucket", 0, 0, 0, Hasil{Sisa: 0, Reset: 2 * time.Second, Tunggu: 500 * time.Millisecond}},
+		{"refilled", 0, time.Second, 1, Hasil{Boleh: true, Sisa: 1, Reset: 1500 * time.Millisecond}},
+		{"refill capped at burst", 2, time.Hour, 3, Hasil{Boleh: true, Sisa: 3, Reset: 500 * time.Millisecond}},
+		{"partly refilled", 0, 250 * time.Millisecond, 0.5, Hasil{Sisa: 0, Reset: 1750 * time.Millisecond, Tunggu: 250 * time.Millisecond}},
+		{"clock went back", 2, -time.Second, 1, Hasil{Boleh: true, Sisa: 1, Reset: 1500 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, hasil := take(tt.tokens, last, p, last.Add(tt.elapsed))
+			if left != tt.left || hasil != tt.hasil {
+				t.Errorf("take() = %v, %+v; want %v, %+v", left, hasil, tt.left, tt.hasil)
+			}
+		})
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]Policy
+		wantErr bool
+	}{
+		{"", map[string]Policy{}, false},
+		{"pegawai=60/m:20, login=5/m", map[string]Policy{
+			"pegawai": {Rate: 1, Burst: 20},
+			"login":   {Rate: 5.0 / 60, Burst: 5},
+		}, false},
+		{"ip=100/s", map[string]Policy{"ip": {Rate: 100, Burst: 100}}, false},
+		{"export=36/h", map[string]Policy{"export": {Rate: 0.01, Burst: 36}}, false},
+		{"login=0/m", map[string]Policy{"login": {Rate: 0, Burst: 0}}, false},
+		{"login", nil, true},
+		{"=5/m", nil, true},
+		{"login=5", nil, true},
+		{"login=-5/m", nil, true},
+		{"login=5/d", nil, true},
+		{"login=5/m:0", nil, true},
+		{"login=5/m:x", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePolicies(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePolicies(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePolicies(%q) = %v; want %v", tt.value, got, tt.want)
+		}
+	}
+}
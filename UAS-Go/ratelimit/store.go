@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sweepInterval is how often full buckets are forgotten.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	penuh  time.Time // when the bucket is full again and can be forgotten
+}
+
+// Memory keeps the buckets in the memory of the process.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweep   time.Time
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket)}
+}
+
+func (m *Memory) Take(_ context.Context, key string, p Policy, now time.Time) (Hasil, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.sweep) >= sweepInterval {
+		for k, b := range m.buckets {
+			if !now.Before(b.penuh) {
+				delete(m.buckets, k)
+			}
+		}
+		m.sweep = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(p.Burst), last: now}
+		m.buckets[key] = b
+	}
+	var hasil Hasil
+	b.tokens, hasil = take(b.tokens, b.last, p, now)
+	b.last = now
+	b.penuh = now.Add(hasil.Reset)
+	return hasil, nil
+}
+
+// Bucket is a bucket shared through the database. Diperbarui is when Tokens
+// was computed, in Unix nanoseconds so it doesn't depend on the time zone
+// of the connection.
+type Bucket struct {
+	Key        string `gorm:"size:191;primaryKey"`
+	Tokens     float64
+	Diperbarui int64
+	Penuh      int64 `gorm:"index"`
+}
+
+func (Bucket) TableName() string {
+	return "rate_limit"
+}
+
+// DB keeps the buckets in a table, so every instance of a service sees the
+// same budget. Each request costs a short transaction on the primary key.
+type DB struct {
+	db *gorm.DB
+
+	mu    sync.Mutex
+	sweep time.Time
+}
+
+// NewDB returns a store in the rate_limit table of db, creating the table if
+// needed.
+func NewDB(db *gorm.DB) (*DB, error) {
+	if db == nil {
+		return nil, errors.New("ratelimit: the db store needs a database")
+	}
+	if err := db.AutoMigrate(&Bucket{}); err != nil {
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+func (s *DB) Take(ctx context.Context, key string, p Policy, now time.Time) (Hasil, error) {
+	s.hapusPenuh(ctx, now)
+
+	var hasil Hasil
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&Bucket{Key: key, Tokens: float64(p.Burst), Diperbarui: now.UnixNano()}).Error
+		if err != nil {
+			return err
+		}
+		var b Bucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&b, "`key` = ?", key).Error; err != nil {
+			return err
+		}
+		b.Tokens, hasil = take(b.Tokens, time.Unix(0, b.Diperbarui), p, now)
+		if now.UnixNano() > b.Diperbarui {
+			b.Diperbarui = now.UnixNano()
+		}
+		b.Penuh = now.Add(hasil.Reset).UnixNano()
+		return tx.Save(&b).Error
+	})
+	return hasil, err
+}
+
+// hapusPenuh deletes full buckets, at most once per sweepInterval per
+// instance.
+func (s *DB) hapusPenuh(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.sweep) < sweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.sweep = now
+	s.mu.Unlock()
+	s.db.WithContext(ctx).Where("penuh <= ?", now.UnixNano()).Delete(&Bucket{})
+}
@@ -8,7 +8,14 @@ import (
 	"strconv"
 	"time"
 
+	"uas/audit"
+	"uas/auth"
+	"uas/i18n"
+	"uas/keamanan"
+	"uas/ratelimit"
+
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -32,10 +39,10 @@ func initDB() (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = db.AutoMigrate(&StatusPegawai{})
-    if err != nil {
-        return nil, err
-    }
+	err = db.AutoMigrate(&StatusPegawai{}, &TransisiStatusPegawai{}, &i18n.Terjemahan{}, &auth.TokenDicabut{}, &audit.Log{})
+	if err != nil {
+		return nil, err
+	}
 
 	return db, nil
 }
@@ -46,24 +53,54 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	limiter, err := ratelimit.FromEnv(db)
+	if err != nil {
+		panic(err)
+	}
+	keamananConfig, err := keamanan.FromEnv()
+	if err != nil {
+		panic(err)
+	}
 	// inisialisasi handler
 	statusPegawaiHandler := NewStatusPegawaiHandler(db)
+	terjemahanHandler := i18n.NewTerjemahanHandler(db, "status_pegawai")
 
 	e := echo.New()
+	e.IPExtractor = limiter.IPExtractor()
+	e.Use(middleware.RequestID())
+	e.Use(keamanan.Middleware(keamananConfig))
+	e.Use(limiter.PerIP(ratelimit.GroupIP))
+	e.Use(auth.Middleware(db))
+	e.Use(limiter.PerClient(ratelimit.GroupDefault))
+	e.Use(auth.RequireLookup(db))
 	// routing
 	e.GET("/statuspegawai", statusPegawaiHandler.GetAllStatusPegawai)
 	e.GET("/statuspegawai/:id", statusPegawaiHandler.GetStatusPegawaiByID)
 	e.POST("/statuspegawai", statusPegawaiHandler.CreateStatusPegawai)
 	e.PUT("/statuspegawai/:id", statusPegawaiHandler.UpdateStatusPegawai)
 	e.DELETE("/statuspegawai/:id", statusPegawaiHandler.DeleteStatusPegawai)
+	e.GET("/statuspegawai/:id/terjemahan", terjemahanHandler.GetAllTerjemahan)
+	e.PUT("/statuspegawai/:id/terjemahan/:locale", terjemahanHandler.SetTerjemahan)
+	e.DELETE("/statuspegawai/:id/terjemahan/:locale", terjemahanHandler.DeleteTerjemahan)
+	e.GET("/statuspegawai/:id/transisi", statusPegawaiHandler.GetAllTransisi)
+	e.POST("/statuspegawai/:id/transisi", statusPegawaiHandler.CreateTransisi)
+	e.DELETE("/statuspegawai/:id/transisi/:ke_id", statusPegawaiHandler.DeleteTransisi)
 	e.Logger.Fatal(e.Start(":1882"))
 }
 
+// StatusPegawai is an employment status. Statuses flagged Dari_Semua may be
+// entered from any status, e.g. Diberhentikan; Berhenti ends the employment,
+// e.g. Pensiun.
 type StatusPegawai struct {
-	ID     				int64  `json:"id"`
-	Status_Pegawai   	string `json:"status_pegawai"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID             int64     `json:"id"`
+	Status_Pegawai string    `json:"status_pegawai"`
+	Dari_Semua     bool      `json:"dari_semua"`
+	Berhenti       bool      `json:"berhenti"`
+	Kode           string    `gorm:"size:20" json:"kode"`
+	Urutan         int       `json:"urutan"`
+	Aktif          bool      `gorm:"default:true" json:"aktif"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 func (StatusPegawai) TableName() string {
@@ -79,20 +116,31 @@ func NewStatusPegawaiHandler(db *gorm.DB) *StatusPegawaiHandler {
 }
 
 type StatusPegawaiRequest struct {
-	ID     			  string `param:"id"`
-	Status_Pegawai    string `json:"status_pegawai"`
+	ID             string `param:"id"`
+	Status_Pegawai string `json:"status_pegawai"`
+	Dari_Semua     bool   `json:"dari_semua"`
+	Berhenti       bool   `json:"berhenti"`
+	Kode           string `json:"kode"`
+	Urutan         int    `json:"urutan"`
+	Aktif          *bool  `json:"aktif"`
 }
 
 func (h *StatusPegawaiHandler) GetAllStatusPegawai(ctx echo.Context) error {
 	search := ctx.QueryParam("search")
 	statuspegawai := make([]*StatusPegawai, 0)
-	query := h.db.Model(&StatusPegawai{})
+	query := h.db.Model(&StatusPegawai{}).Order("urutan").Order("id")
+	if ctx.QueryParam("include_inactive") != "true" {
+		query = query.Where("aktif = ?", true)
+	}
 	if search != "" {
 		query = query.Where("statuspegawai LIKE ?", "%"+search+"%")
 	}
 	if err := query.Find(&statuspegawai).Error; err != nil { // SELECT * FROM users
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Status Pegawai"})
 	}
+	if err := i18n.Apply(h.db, "status_pegawai", i18n.Locales(ctx), statuspegawai, func(x *StatusPegawai) (int64, *string) { return x.ID, &x.Status_Pegawai }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Status Pegawai"})
+	}
 	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Users", "data": statuspegawai, "filter": search})
 }
 
@@ -103,11 +151,27 @@ func (h *StatusPegawaiHandler) CreateStatusPegawai(ctx echo.Context) error {
 	}
 
 	statuspegawai := &StatusPegawai{
-		Status_Pegawai:    input.Status_Pegawai,
-		CreatedAt: time.Now(),
+		Status_Pegawai: input.Status_Pegawai,
+		Kode:           input.Kode,
+		Urutan:         input.Urutan,
+		Dari_Semua:     input.Dari_Semua,
+		Berhenti:       input.Berhenti,
+		CreatedAt:      time.Now(),
 	}
 
-	if err := h.db.Create(statuspegawai).Error; err != nil { // INSERT INTO users (nim, nama, alamat) VALUES('')
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(statuspegawai).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil && !*input.Aktif {
+			if err := tx.Model(statuspegawai).Update("aktif", false).Error; err != nil {
+				return err
+			}
+			statuspegawai.Aktif = false
+		}
+		return audit.Catat(tx, ctx, "status_pegawai", statuspegawai.ID, nil, statuspegawai)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Create Status Pegawai"})
 	}
 
@@ -125,6 +189,9 @@ func (h *StatusPegawaiHandler) GetStatusPegawaiByID(ctx echo.Context) error {
 	if err := h.db.Where("id =?", input.ID).First(&statuspegawai).Error; err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Status Pegawai By ID"})
 	}
+	if err := i18n.Apply(h.db, "status_pegawai", i18n.Locales(ctx), []*StatusPegawai{statuspegawai}, func(x *StatusPegawai) (int64, *string) { return x.ID, &x.Status_Pegawai }); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get Status Pegawai By ID"})
+	}
 
 	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": fmt.Sprintf("Succesfully Get Status Pegawai By ID : %s", input.ID), "data": statuspegawai})
 }
@@ -138,13 +205,37 @@ func (h *StatusPegawaiHandler) UpdateStatusPegawai(ctx echo.Context) error {
 	statuspegawaiID, _ := strconv.Atoi(input.ID)
 
 	statuspegawai := StatusPegawai{
-		ID:     int64(statuspegawaiID),
-		Status_Pegawai:    input.Status_Pegawai,
-		UpdatedAt: time.Now(),
+		ID:             int64(statuspegawaiID),
+		Status_Pegawai: input.Status_Pegawai,
+		Kode:           input.Kode,
+		Urutan:         input.Urutan,
+		Dari_Semua:     input.Dari_Semua,
+		Berhenti:       input.Berhenti,
+		UpdatedAt:      time.Now(),
 	}
 
-	query := h.db.Model(&StatusPegawai{}).Where("id = ?", statuspegawaiID)
-	if err := query.Updates(&statuspegawai).Error; err != nil {
+	sebelum := new(StatusPegawai)
+	if err := h.db.First(sebelum, statuspegawaiID).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Status Pegawai not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Select the flags explicitly so they can be switched off again
+		query := tx.Model(&StatusPegawai{}).Where("id = ?", statuspegawaiID).Select("status_pegawai", "kode", "urutan", "dari_semua", "berhenti", "updated_at")
+		if err := query.Updates(&statuspegawai).Error; err != nil {
+			return err
+		}
+		if input.Aktif != nil {
+			if err := tx.Model(&StatusPegawai{}).Where("id = ?", statuspegawaiID).Update("aktif", *input.Aktif).Error; err != nil {
+				return err
+			}
+		}
+		sesudah := new(StatusPegawai)
+		if err := tx.First(sesudah, statuspegawaiID).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "status_pegawai", statuspegawaiID, sebelum, sesudah)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Update Status Pegawai By ID", "error": err.Error()})
 	}
 
@@ -157,8 +248,114 @@ func (h *StatusPegawaiHandler) DeleteStatusPegawai(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
 	}
 
-	if err := h.db.Delete(&StatusPegawai{}, input.ID).Error; err != nil {
+	sebelum := new(StatusPegawai)
+	if err := h.db.Where("id = ?", input.ID).First(sebelum).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Status Pegawai not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(sebelum).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "status_pegawai", sebelum.ID, sebelum, nil)
+	})
+	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Delete Status Pegawai By ID"})
 	}
 	return ctx.JSON(http.StatusNoContent, nil)
-}
\ No newline at end of file
+}
+
+// TransisiStatusPegawai allows employees to move from status Dari_ID to
+// Ke_ID. Statuses flagged Dari_Semua don't need a row here. Dari_ID 0 stands
+// for employees without a status yet, configured at /statuspegawai/0/transisi;
+// new employees can only start in those statuses.
+type TransisiStatusPegawai struct {
+	Dari_ID   int64     `gorm:"primaryKey;autoIncrement:false" json:"dari_id"`
+	Ke_ID     int64     `gorm:"primaryKey;autoIncrement:false" json:"ke_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (TransisiStatusPegawai) TableName() string {
+	return "transisi_status_pegawai"
+}
+
+// key identifies the row in the audit log.
+func (t *TransisiStatusPegawai) key() string {
+	return fmt.Sprintf("%d:%d", t.Dari_ID, t.Ke_ID)
+}
+
+type TransisiRequest struct {
+	Dari_ID string `param:"id"`
+	Ke_ID   int64  `json:"ke_id" param:"ke_id"`
+}
+
+func (h *StatusPegawaiHandler) GetAllTransisi(ctx echo.Context) error {
+	var input TransisiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	transisi := make([]*TransisiStatusPegawai, 0)
+	if err := h.db.Where("dari_id = ?", input.Dari_ID).Find(&transisi).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Get All Transisi"})
+	}
+	return ctx.JSON(http.StatusOK, map[string]interface{}{"message": "Succesfully Get All Transisi", "data": transisi})
+}
+
+func (h *StatusPegawaiHandler) CreateTransisi(ctx echo.Context) error {
+	var input TransisiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	dariID, err := strconv.ParseInt(input.Dari_ID, 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid ID"})
+	}
+	// Employees without a status yet move from 0, which has no row
+	ids := []int64{input.Ke_ID}
+	if dariID != 0 {
+		ids = append(ids, dariID)
+	}
+	var count int64
+	if err := h.db.Model(&StatusPegawai{}).Where("id IN ?", ids).Count(&count).Error; err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Create Transisi"})
+	}
+	if dariID == input.Ke_ID || count != int64(len(ids)) {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Transisi needs two different existing Status Pegawai"})
+	}
+
+	transisi := &TransisiStatusPegawai{Dari_ID: dariID, Ke_ID: input.Ke_ID}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(transisi).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "transisi_status_pegawai", transisi.key(), nil, transisi)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Create Transisi"})
+	}
+
+	return ctx.JSON(http.StatusCreated, map[string]interface{}{"message": "Succesfully Create a Transisi", "data": transisi})
+}
+
+func (h *StatusPegawaiHandler) DeleteTransisi(ctx echo.Context) error {
+	var input TransisiRequest
+	if err := ctx.Bind(&input); err != nil {
+		return ctx.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to Bind Input"})
+	}
+
+	transisi := new(TransisiStatusPegawai)
+	if err := h.db.Where("dari_id = ? AND ke_id = ?", input.Dari_ID, input.Ke_ID).First(transisi).Error; err != nil {
+		return ctx.JSON(http.StatusNotFound, map[string]string{"message": "Transisi not found"})
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("dari_id = ? AND ke_id = ?", transisi.Dari_ID, transisi.Ke_ID).Delete(&TransisiStatusPegawai{}).Error; err != nil {
+			return err
+		}
+		return audit.Catat(tx, ctx, "transisi_status_pegawai", transisi.key(), transisi, nil)
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to Delete Transisi"})
+	}
+	return ctx.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	root := t.TempDir()
+	l := &Local{root: root}
+	tests := []struct {
+		name string
+		want string // relative to root, empty when the name is rejected
+	}{
+		{"a.jpg", "a.jpg"},
+		{"dokumen/2024/a.pdf", filepath.Join("dokumen", "2024", "a.pdf")},
+		{"", ""},
+		{"/", ""},
+		{"/etc/passwd", ""},
+		{"..", ""},
+		{"../a.jpg", ""},
+		{"dokumen/../../a.jpg", ""},
+		{"dokumen/../a.jpg", ""},
+		{"./a.jpg", ""},
+		{"dokumen//a.jpg", ""},
+		{"dokumen/", ""},
+	}
+	for _, tt := range tests {
+		got, err := l.path(tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("path(%q) = %q; want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != filepath.Join(root, tt.want) {
+			t.Errorf("path(%q) = %q, %v; want %q", tt.name, got, err, filepath.Join(root, tt.want))
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	l, err := NewLocal(filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Save(ctx, "gambar/a.jpg", strings.NewReader("foto"), 4, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := l.Open(ctx, "gambar/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "foto" {
+		t.Errorf("Open() read %q, %v; want %q", data, err, "foto")
+	}
+	if info, err := l.Stat(ctx, "gambar/a.jpg"); err != nil || info.Size != 4 || info.ContentType != "image/jpeg" {
+		t.Errorf("Stat() = %+v, %v", info, err)
+	}
+
+	if err := l.Move(ctx, "gambar/a.jpg", "gambar/b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Save(ctx, "dokumen/c.pdf", strings.NewReader("pdf"), 3, "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := l.List(ctx, "gambar/"); err != nil || !reflect.DeepEqual(names, []string{"gambar/b.jpg"}) {
+		t.Errorf("List() = %q, %v; want [gambar/b.jpg]", names, err)
+	}
+
+	if err := l.Delete(ctx, "gambar/b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Open(ctx, "gambar/b.jpg"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Open() of a deleted file error = %v; want ErrNotExist", err)
+	}
+	if err := l.Delete(ctx, "gambar/b.jpg"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Delete() of a deleted file error = %v; want ErrNotExist", err)
+	}
+	if err := l.Move(ctx, "gambar/b.jpg", "gambar/d.jpg"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Move() of a deleted file error = %v; want ErrNotExist", err)
+	}
+}
+
+// TestLocalEscape checks that no operation reaches outside the root.
+func TestLocalEscape(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	l, err := NewLocal(filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	luar := filepath.Join(dir, "luar.txt")
+	if err := os.WriteFile(luar, []byte("rahasia"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Save(ctx, "../baru.txt", strings.NewReader("x"), 1, ""); err == nil {
+		t.Error("Save() outside the root succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "baru.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Save() created a file outside the root: %v", err)
+	}
+	if _, err := l.Open(ctx, "../luar.txt"); err == nil {
+		t.Error("Open() outside the root succeeded")
+	}
+	if _, err := l.Stat(ctx, "../luar.txt"); err == nil {
+		t.Error("Stat() outside the root succeeded")
+	}
+	if err := l.Move(ctx, "../luar.txt", "dicuri.txt"); err == nil {
+		t.Error("Move() from outside the root succeeded")
+	}
+	if err := l.Delete(ctx, "../luar.txt"); err == nil {
+		t.Error("Delete() outside the root succeeded")
+	}
+	if data, err := os.ReadFile(luar); err != nil || string(data) != "rahasia" {
+		t.Errorf("file outside the root = %q, %v; want it unchanged", data, err)
+	}
+}
@@ -0,0 +1,79 @@
+// Package storage abstracts where uploaded files live. Files are addressed by
+// a slash separated name relative to the configured root directory or bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ErrNotExist is returned when a file does not exist.
+var ErrNotExist = errors.New("storage: file does not exist")
+
+// Info describes a stored file.
+type Info struct {
+	Name        string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// File is an open stored file. It supports seeking so it can be served with
+// Range requests.
+type File interface {
+	io.ReadSeekCloser
+	Stat() (Info, error)
+}
+
+// Storage is a file storage backend.
+type Storage interface {
+	// Save stores the content of r under name, replacing any existing file.
+	Save(ctx context.Context, name string, r io.Reader, size int64, contentType string) error
+	// Open opens the file stored under name for reading.
+	Open(ctx context.Context, name string) (File, error)
+	// Stat returns information about the file stored under name.
+	Stat(ctx context.Context, name string) (Info, error)
+	// Move renames the file stored under from to to, replacing any existing
+	// file.
+	Move(ctx context.Context, from, to string) error
+	// Delete removes the file stored under name.
+	Delete(ctx context.Context, name string) error
+	// List returns the names of all files whose name starts with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// FromEnv builds the backend selected by STORAGE_DRIVER:
+//
+//	local (default)  files under STORAGE_ROOT (default "upload")
+//	s3               objects in S3_BUCKET on S3_ENDPOINT, authenticated with
+//	                 S3_ACCESS_KEY and S3_SECRET_KEY; S3_USE_SSL and S3_REGION
+//	                 are optional
+func FromEnv() (Storage, error) {
+	switch driver := getEnv("STORAGE_DRIVER", "local"); driver {
+	case "local":
+		return NewLocal(getEnv("STORAGE_ROOT", "upload"))
+	case "s3":
+		useSSL, _ := strconv.ParseBool(getEnv("S3_USE_SSL", "true"))
+		return NewS3(context.Background(), S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Region:    os.Getenv("S3_REGION"),
+			UseSSL:    useSSL,
+		})
+	default:
+		return nil, errors.New("storage: unknown STORAGE_DRIVER " + strconv.Quote(driver))
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
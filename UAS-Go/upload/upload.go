@@ -0,0 +1,70 @@
+// Package upload stores multipart file uploads in a storage backend. It is
+// shared by the pegawai services.
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"path/filepath"
+	"time"
+
+	"uas/storage"
+)
+
+// File describes a stored upload.
+type File struct {
+	Name        string // generated file name, relative to the prefix it was saved under
+	Size        int64
+	Checksum    string // hex encoded SHA-256 of the content
+	ContentType string
+}
+
+// Save copies file into store under prefix with a unique generated name and
+// returns its metadata. An empty prefix stores the file at the root.
+func Save(ctx context.Context, store storage.Storage, file *multipart.FileHeader, prefix string) (*File, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	return SaveReader(ctx, store, src, file.Size, file.Filename, file.Header.Get("Content-Type"), prefix)
+}
+
+// SaveReader is Save for content read from r. filename is only used to
+// derive the generated name.
+func SaveReader(ctx context.Context, store storage.Storage, r io.Reader, size int64, filename, contentType, prefix string) (*File, error) {
+	// Generate a unique filename for the uploaded file
+	name := fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(filename))
+
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(r, hash)}
+	if err := store.Save(ctx, path.Join(prefix, name), counter, size, contentType); err != nil {
+		return nil, err
+	}
+
+	return &File{
+		Name:        name,
+		Size:        counter.n,
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+		ContentType: contentType,
+	}, nil
+}
+
+// countingReader counts the bytes read, so the size is right even when the
+// caller doesn't know it up front.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}